@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+//layout of the backup timestamps, sorts chronologically
+const backupTimeLayout = "20060102T150405Z"
+
+//backupScheduler periodically saves the dataset of the instance with BGSAVE and copies the RDB file
+//to <dir>/<uid>-<timestamp>.rdb, keeping the last keep backups
+type backupScheduler struct {
+	instance *instance
+	rdb      string //host path of the redis RDB file
+	dir      string
+	interval time.Duration
+	timeout  time.Duration //of BGSAVE, independent of the interval: saving a large dataset may take longer
+	keep     int
+	stop     chan struct{}
+	done     chan struct{}
+	log      *logger
+}
+
+func newBackupScheduler(i *instance, rdb string, c *cli.Context, log *logger) (*backupScheduler, error) {
+	b := &backupScheduler{
+		instance: i,
+		rdb:      rdb,
+		dir:      c.GlobalString("backup-dir"),
+		interval: c.GlobalDuration("backup-interval"),
+		timeout:  c.GlobalDuration("backup-timeout"),
+		keep:     c.GlobalInt("backup-keep"),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		log:      log,
+	}
+	if b.dir == "" {
+		return nil, fmt.Errorf("--backup-interval requires --backup-dir")
+	}
+	if b.timeout <= 0 {
+		return nil, fmt.Errorf("invalid --backup-timeout %s", b.timeout)
+	}
+	if b.keep < 0 {
+		return nil, fmt.Errorf("invalid --backup-keep %d", b.keep)
+	}
+	if err := os.MkdirAll(b.dir, 0700); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *backupScheduler) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		}
+		file, err := b.backup()
+		if err != nil {
+			b.log.Println("backup failed:", err)
+			continue
+		}
+		b.log.Println("backup written to", file)
+		if err := b.prune(); err != nil {
+			b.log.Println("failed to prune backups:", err)
+		}
+	}
+}
+
+//stops the scheduler and waits for the running backup to complete
+func (b *backupScheduler) shutdown() {
+	close(b.stop)
+	<-b.done
+}
+
+func (b *backupScheduler) backup() (string, error) {
+	if err := bgsave(b.instance, b.timeout); err != nil {
+		return "", err
+	}
+	file := path.Join(b.dir, fmt.Sprintf("%s-%s.rdb", b.instance.UID, time.Now().UTC().Format(backupTimeLayout)))
+	if err := copyFile(b.rdb, file); err != nil {
+		return "", err
+	}
+	if err := verifyRDB(file); err != nil {
+		os.Remove(file)
+		return "", err
+	}
+	return file, nil
+}
+
+//removes the oldest backups of the instance, keeping the last keep ones (all of them if keep is 0)
+func (b *backupScheduler) prune() error {
+	if b.keep == 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, f := range files {
+		if b.isBackup(f.Name()) {
+			backups = append(backups, f.Name())
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > b.keep {
+		if err := os.Remove(path.Join(b.dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+//returns true if name is exactly <uid>-<timestamp>.rdb, the uid of another instance may start with this one (e.g: cache and cache-2)
+func (b *backupScheduler) isBackup(name string) bool {
+	prefix := b.instance.UID + "-"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".rdb") {
+		return false
+	}
+	_, err := time.Parse(backupTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".rdb"))
+	return err == nil
+}
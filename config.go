@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/docker/pkg/units"
+	"gopkg.in/yaml.v2"
+)
+
+//instanceConfig describes a whole instance. It is loaded from --config-file (json or yaml)
+//and command line flags take precedence over it
+type instanceConfig struct {
+	Redis       []string          `json:"redis" yaml:"redis"` //redis.conf directives, e.g: "requirepass foobar"
+	Network     networkConfig     `json:"network" yaml:"network"`
+	Limits      resourceLimits    `json:"limits" yaml:"limits"`
+	Volumes     volumesConfig     `json:"volumes" yaml:"volumes"`
+	UnixSocket  unixSocketConfig  `json:"unix_socket" yaml:"unix_socket"`
+	Replication replicationConfig `json:"replication" yaml:"replication"`
+	Sentinel    *sentinelConfig   `json:"sentinel" yaml:"sentinel"` //runs redis-server in sentinel mode when set
+}
+
+//if IP and IPv6 are empty, the host network is used
+type networkConfig struct {
+	IP          string   `json:"ip" yaml:"ip"`
+	IPv6        string   `json:"ipv6" yaml:"ipv6"`
+	Bridge      string   `json:"bridge" yaml:"bridge"`
+	Subnet      string   `json:"subnet" yaml:"subnet"` //CIDR
+	Gateway     string   `json:"gateway" yaml:"gateway"`
+	IPv6Subnet  string   `json:"ipv6_subnet" yaml:"ipv6_subnet"` //CIDR
+	IPv6Gateway string   `json:"ipv6_gateway" yaml:"ipv6_gateway"`
+	Ports       []string `json:"ports" yaml:"ports"` //published on the host, hostport:containerport
+}
+
+//returns true if the container uses the net namespace
+func (n *networkConfig) enabled() bool {
+	return n.IP != "" || n.IPv6 != ""
+}
+
+//fills the bridge defaults and checks the gateways belong to the subnets
+func (n *networkConfig) validate() error {
+	if n.Bridge == "" {
+		n.Bridge = defaultBridge
+	}
+	if n.Subnet == "" {
+		n.Subnet = defaultSubnet
+		if n.Gateway == "" {
+			n.Gateway = defaultGateway
+		}
+	}
+	if n.IPv6Subnet == "" {
+		n.IPv6Subnet = defaultIPv6Subnet
+		if n.IPv6Gateway == "" {
+			n.IPv6Gateway = defaultIPv6Gateway
+		}
+	}
+	var err error
+	if n.Gateway, err = validateSubnet(n.Subnet, n.Gateway, false); err != nil {
+		return err
+	}
+	if n.IPv6Gateway, err = validateSubnet(n.IPv6Subnet, n.IPv6Gateway, true); err != nil {
+		return err
+	}
+	if len(n.Ports) > 0 && n.IP == "" {
+		return fmt.Errorf("publishing ports requires an IPv4 address (-i)")
+	}
+	for _, spec := range n.Ports {
+		if _, err := parsePortMapping(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//checks the subnet is of the expected address family and the gateway belongs to it, the gateway defaults
+//to the first address of the subnet
+func validateSubnet(subnet, gateway string, ipv6 bool) (string, error) {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return "", fmt.Errorf("invalid subnet %s: %v", subnet, err)
+	}
+	if isIPv6 := len(ipNet.IP) != net.IPv4len; isIPv6 != ipv6 {
+		family := "IPv4"
+		if ipv6 {
+			family = "IPv6"
+		}
+		return "", fmt.Errorf("invalid subnet %s, expecting an %s subnet", subnet, family)
+	}
+	if gateway == "" {
+		gateway = nextIP(ipNet.IP).String()
+	}
+	if ip := net.ParseIP(gateway); ip == nil || !ipNet.Contains(ip) {
+		return "", fmt.Errorf("invalid gateway %s, expecting an address of %s", gateway, subnet)
+	}
+	return gateway, nil
+}
+
+//bind directive listening on the container addresses
+func (n *networkConfig) bind() string {
+	bind := "bind 127.0.0.1"
+	for _, ip := range []string{n.IP, n.IPv6} {
+		if ip != "" {
+			bind += " " + ip
+		}
+	}
+	return bind
+}
+
+func (n *networkConfig) subnet() (*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(n.Subnet)
+	return ipNet, err
+}
+
+type volumesConfig struct {
+	DataDir string `json:"data_dir" yaml:"data_dir"` //bind mounted on containerDataDir
+}
+
+//when Path is set, redis only listens on a unix socket whose directory is bind mounted from the host
+type unixSocketConfig struct {
+	Path  string `json:"path" yaml:"path"`   //host path of the socket
+	Owner string `json:"owner" yaml:"owner"` //uid:gid owning the socket on the host
+	Perm  string `json:"perm" yaml:"perm"`   //octal permissions of the socket
+}
+
+func (u *unixSocketConfig) validate() error {
+	if u.Path == "" {
+		return nil
+	}
+	if u.Perm == "" {
+		u.Perm = "770"
+	}
+	if _, err := strconv.ParseUint(u.Perm, 8, 32); err != nil {
+		return fmt.Errorf("invalid unix socket permissions %s, expecting an octal mode", u.Perm)
+	}
+	if u.Owner != "" {
+		if _, _, err := u.owner(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//parses the uid:gid owner, the gid defaults to the uid
+func (u *unixSocketConfig) owner() (int, int, error) {
+	parts := strings.Split(u.Owner, ":")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("invalid unix socket owner %s, expecting uid:gid", u.Owner)
+	}
+	var ids []int
+	for _, part := range parts {
+		id, err := strconv.Atoi(part)
+		if err != nil || id < 0 {
+			return 0, 0, fmt.Errorf("invalid unix socket owner %s, expecting numeric uid:gid", u.Owner)
+		}
+		ids = append(ids, id)
+	}
+	return ids[0], ids[len(ids)-1], nil
+}
+
+//path of the socket inside the container
+func (u *unixSocketConfig) containerPath() string {
+	return path.Join(containerSocketDir, filepath.Base(u.Path))
+}
+
+//host directory bind mounted on containerSocketDir, next to the socket path and dedicated to the instance uid
+//so the container doesn't see the rest of the socket directory. The socket path is a symlink to its socket
+func (u *unixSocketConfig) hostDir(uid string) string {
+	return filepath.Join(filepath.Dir(u.Path), ".sc-redis-"+uid)
+}
+
+//host path of the socket created by redis-server, the target of the socket path symlink
+func (u *unixSocketConfig) hostSocket(uid string) string {
+	return filepath.Join(u.hostDir(uid), filepath.Base(u.Path))
+}
+
+//size in bytes, unmarshaled from a number or a human readable size (e.g: 512m, 1g or -1)
+type byteSize int64
+
+func (s *byteSize) set(value string) error {
+	if value == "-1" {
+		*s = -1
+		return nil
+	}
+	size, err := units.RAMInBytes(value)
+	if err != nil {
+		return err
+	}
+	*s = byteSize(size)
+	return nil
+}
+
+func (s *byteSize) UnmarshalJSON(data []byte) error {
+	if value, err := strconv.Unquote(string(data)); err == nil {
+		return s.set(value)
+	}
+	return s.set(string(data))
+}
+
+func (s *byteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	return s.set(value)
+}
+
+//duration unmarshaled from a string, e.g: 30s, 3m
+type duration time.Duration
+
+func (d *duration) set(value string) error {
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return d.set(value)
+}
+
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	return d.set(value)
+}
+
+//sentinel is the configuration given to the sentinel command, nil otherwise
+func newInstanceConfig(c *cli.Context, sentinel *sentinelConfig) (*instanceConfig, error) {
+	conf := &instanceConfig{}
+	if file := c.GlobalString("config-file"); file != "" {
+		if err := conf.load(file); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %v", file, err)
+		}
+	}
+	if sentinel != nil {
+		if conf.Sentinel == nil {
+			conf.Sentinel = &sentinelConfig{}
+		}
+		conf.Sentinel.Masters = append(conf.Sentinel.Masters, sentinel.Masters...)
+	}
+
+	conf.Redis = append(conf.Redis, splitRawConf(c.GlobalString("config"))...)
+	if ip := c.GlobalString("ip"); ip != "" {
+		conf.Network.IP = ip
+	}
+	if bridge := c.GlobalString("bridge"); bridge != "" {
+		conf.Network.Bridge = bridge
+	}
+	if subnet := c.GlobalString("subnet"); subnet != "" {
+		conf.Network.Subnet = subnet
+	}
+	if gateway := c.GlobalString("gateway"); gateway != "" {
+		conf.Network.Gateway = gateway
+	}
+	if ipv6 := c.GlobalString("ipv6"); ipv6 != "" {
+		conf.Network.IPv6 = ipv6
+	}
+	if subnet := c.GlobalString("ipv6-subnet"); subnet != "" {
+		conf.Network.IPv6Subnet = subnet
+	}
+	if gateway := c.GlobalString("ipv6-gateway"); gateway != "" {
+		conf.Network.IPv6Gateway = gateway
+	}
+	conf.Network.Ports = append(conf.Network.Ports, c.GlobalStringSlice("publish")...)
+	if dataDir := c.GlobalString("data-dir"); dataDir != "" {
+		conf.Volumes.DataDir = dataDir
+	}
+	if socket := c.GlobalString("unix-socket"); socket != "" {
+		conf.UnixSocket.Path = socket
+	}
+	if owner := c.GlobalString("unix-socket-owner"); owner != "" {
+		conf.UnixSocket.Owner = owner
+	}
+	if perm := c.GlobalString("unix-socket-perm"); perm != "" {
+		conf.UnixSocket.Perm = perm
+	}
+	if master := c.GlobalString("replica-of"); master != "" {
+		conf.Replication.ReplicaOf = master
+	}
+	if auth := c.GlobalString("master-auth"); auth != "" {
+		conf.Replication.MasterAuth = auth
+	}
+	if memory := c.GlobalString("memory"); memory != "" {
+		if err := conf.Limits.Memory.set(memory); err != nil {
+			return nil, fmt.Errorf("invalid memory limit %s: %v", memory, err)
+		}
+	}
+	if swap := c.GlobalString("memory-swap"); swap != "" {
+		if err := conf.Limits.MemorySwap.set(swap); err != nil {
+			return nil, fmt.Errorf("invalid memory-swap limit %s: %v", swap, err)
+		}
+	}
+	if shares := c.GlobalInt("cpu-shares"); shares != 0 {
+		conf.Limits.CpuShares = int64(shares)
+	}
+	if cpuset := c.GlobalString("cpuset"); cpuset != "" {
+		conf.Limits.CpusetCpus = cpuset
+	}
+	return conf, conf.validate()
+}
+
+//makes the paths absolute, fills the defaults and checks the configuration
+func (conf *instanceConfig) validate() error {
+	if conf.Volumes.DataDir != "" {
+		dataDir, err := filepath.Abs(conf.Volumes.DataDir)
+		if err != nil {
+			return err
+		}
+		conf.Volumes.DataDir = dataDir
+	}
+	if conf.UnixSocket.Path != "" {
+		socket, err := filepath.Abs(conf.UnixSocket.Path)
+		if err != nil {
+			return err
+		}
+		conf.UnixSocket.Path = socket
+	}
+	if err := conf.Network.validate(); err != nil {
+		return err
+	}
+	if err := conf.UnixSocket.validate(); err != nil {
+		return err
+	}
+	if conf.Sentinel != nil {
+		if conf.UnixSocket.Path != "" || conf.Replication.ReplicaOf != "" {
+			return fmt.Errorf("unix socket and replication are not available in sentinel mode")
+		}
+		if err := conf.Sentinel.validate(); err != nil {
+			return err
+		}
+	}
+	return conf.Limits.validate()
+}
+
+//loads a json (.json) or yaml (.yml, .yaml) config file. Relative paths are resolved from the file directory
+func (conf *instanceConfig) load(file string) error {
+	if err := unmarshalFile(file, conf); err != nil {
+		return err
+	}
+	conf.resolvePaths(filepath.Dir(file))
+	return nil
+}
+
+//unmarshals a json (.json) or yaml (.yml, .yaml) file in v
+func unmarshalFile(file string, v interface{}) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return json.Unmarshal(data, v)
+	case ".yml", ".yaml":
+		return yaml.Unmarshal(data, v)
+	}
+	return fmt.Errorf("unknown format, expecting .json, .yml or .yaml")
+}
+
+//resolves the relative paths of a loaded configuration from dir and trims the redis directives
+func (conf *instanceConfig) resolvePaths(dir string) {
+	if dataDir := conf.Volumes.DataDir; dataDir != "" && !filepath.IsAbs(dataDir) {
+		conf.Volumes.DataDir = filepath.Join(dir, dataDir)
+	}
+	if socket := conf.UnixSocket.Path; socket != "" && !filepath.IsAbs(socket) {
+		conf.UnixSocket.Path = filepath.Join(dir, socket)
+	}
+	for i, line := range conf.Redis {
+		conf.Redis[i] = strings.TrimSpace(line)
+	}
+}
+
+//redis directives of the instance: the user ones followed by the ones derived from the instance configuration
+func (conf *instanceConfig) redisDirectives() []string {
+	if conf.Sentinel != nil {
+		return conf.sentinelDirectives()
+	}
+	directives := append([]string{}, conf.Redis...)
+	if conf.Volumes.DataDir != "" {
+		directives = append(directives, "dir "+containerDataDir)
+	}
+	if conf.UnixSocket.Path != "" {
+		directives = append(directives,
+			"port 0",
+			"unixsocket "+conf.UnixSocket.containerPath(),
+			"unixsocketperm "+conf.UnixSocket.Perm,
+		)
+	}
+	if conf.Network.enabled() && !hasDirective(conf.Redis, "bind") {
+		directives = append(directives, conf.Network.bind())
+	}
+	if conf.Replication.ReplicaOf != "" && !hasDirective(conf.Redis, "slaveof") {
+		directives = append(directives, conf.Replication.directives()...)
+	}
+	if conf.Limits.Memory > 0 && !hasDirective(conf.Redis, "maxmemory") {
+		directives = append(directives, fmt.Sprintf("maxmemory %d", conf.Limits.maxmemory()))
+	}
+	return directives
+}
+
+//sentinel.conf directives: the user ones, the bind address and the monitored masters
+func (conf *instanceConfig) sentinelDirectives() []string {
+	var directives []string
+	if !hasDirective(conf.Redis, "port") {
+		directives = append(directives, fmt.Sprintf("port %d", defaultSentinelPort))
+	}
+	directives = append(directives, conf.Redis...)
+	if conf.Network.enabled() && !hasDirective(conf.Redis, "bind") {
+		directives = append(directives, conf.Network.bind())
+	}
+	return append(directives, conf.Sentinel.directives()...)
+}
+
+//command run in the container
+func (conf *instanceConfig) command() []string {
+	if conf.Sentinel != nil {
+		return []string{"redis-server", "/etc/sentinel.conf", "--sentinel"}
+	}
+	return []string{"redis-server", "/etc/redis.conf"}
+}
@@ -1,17 +1,62 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"path"
+	"strings"
 	"syscall"
 
 	"github.com/docker/libcontainer/configs"
 	"github.com/docker/libcontainer/utils"
 )
 
-const defaultMountFlags = syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
+const (
+	defaultMountFlags = syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
 
-//if ipAddr == "", will use host network otherwise, will setup the net namespace
-func loadConfig(uid, rootfs, ipAddr string) *configs.Config {
+	//where the host data directory is mounted inside the container
+	containerDataDir = "/data"
+
+	//where the host directory of the unix socket is mounted inside the container
+	containerSocketDir = "/run/redis"
+
+	//containers cgroups are created under this parent
+	cgroupParent = "sc-redis"
+)
+
+//cgroup limits, zero values mean no limit
+type resourceLimits struct {
+	Memory     byteSize `json:"memory" yaml:"memory"`
+	MemorySwap byteSize `json:"memory_swap" yaml:"memory_swap"` //memory + swap, -1 for unlimited swap
+	CpuShares  int64    `json:"cpu_shares" yaml:"cpu_shares"`
+	CpusetCpus string   `json:"cpuset" yaml:"cpuset"`
+}
+
+func (l *resourceLimits) validate() error {
+	if l.Memory < 0 {
+		return fmt.Errorf("invalid memory limit %d", l.Memory)
+	}
+	if l.MemorySwap != 0 {
+		if l.Memory == 0 {
+			return fmt.Errorf("memory-swap requires a memory limit")
+		}
+		if l.MemorySwap != -1 && l.MemorySwap < l.Memory {
+			return fmt.Errorf("memory-swap must be greater than or equal to memory")
+		}
+	}
+	if l.CpuShares < 0 {
+		return fmt.Errorf("invalid cpu-shares %d", l.CpuShares)
+	}
+	return nil
+}
+
+//redis maxmemory derived from the memory limit, leaves room for redis overhead and background saves
+func (l *resourceLimits) maxmemory() int64 {
+	return int64(l.Memory) / 4 * 3
+}
+
+//if conf.Network.IP and conf.Network.IPv6 are empty, will use host network otherwise, will setup the net namespace
+//if conf.Volumes.DataDir != "", it will be bind mounted on containerDataDir
+func loadConfig(uid, rootfs string, conf *instanceConfig) (*configs.Config, error) {
 	var config = &configs.Config{
 		Rootfs: rootfs,
 		Capabilities: []string{
@@ -38,9 +83,13 @@ func loadConfig(uid, rootfs, ipAddr string) *configs.Config {
 		}),
 		Cgroups: &configs.Cgroup{
 			Name:            uid,
-			Parent:          "sc-redis",
+			Parent:          cgroupParent,
 			AllowAllDevices: false,
 			AllowedDevices:  configs.DefaultAllowedDevices,
+			Memory:          int64(conf.Limits.Memory),
+			MemorySwap:      int64(conf.Limits.MemorySwap),
+			CpuShares:       conf.Limits.CpuShares,
+			CpusetCpus:      conf.Limits.CpusetCpus,
 		},
 
 		Devices: configs.DefaultAutoCreatedDevices,
@@ -94,29 +143,69 @@ func loadConfig(uid, rootfs, ipAddr string) *configs.Config {
 		},
 	}
 
-	if ipAddr != "" {
+	if conf.Volumes.DataDir != "" {
+		config.Mounts = append(config.Mounts, &configs.Mount{
+			Source:      conf.Volumes.DataDir,
+			Destination: containerDataDir,
+			Device:      "bind",
+			Flags:       syscall.MS_BIND | syscall.MS_REC,
+		})
+	}
+
+	if conf.UnixSocket.Path != "" {
+		config.Mounts = append(config.Mounts, &configs.Mount{
+			Source:      conf.UnixSocket.hostDir(uid),
+			Destination: containerSocketDir,
+			Device:      "bind",
+			Flags:       syscall.MS_BIND | syscall.MS_REC,
+		})
+	}
+
+	if conf.Network.enabled() {
 		hostName, err := utils.GenerateRandomName("veth", 7)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
 		config.Namespaces = append(config.Namespaces, configs.Namespace{Type: configs.NEWNET})
+		veth := &configs.Network{
+			Type:              "veth",
+			Bridge:            conf.Network.Bridge,
+			Mtu:               1500,
+			Name:              "eth0",
+			HostInterfaceName: hostName,
+		}
+		if conf.Network.IP != "" {
+			if veth.Address, err = cidrAddr(conf.Network.IP, conf.Network.Subnet); err != nil {
+				return nil, err
+			}
+			veth.Gateway = conf.Network.Gateway
+		}
+		if conf.Network.IPv6 != "" {
+			if veth.IPv6Address, err = cidrAddr(conf.Network.IPv6, conf.Network.IPv6Subnet); err != nil {
+				return nil, err
+			}
+			veth.IPv6Gateway = conf.Network.IPv6Gateway
+		}
 		config.Networks = []*configs.Network{
 			{
 				Type:    "loopback",
 				Address: "127.0.0.1/0",
 				Gateway: "localhost",
 			},
-			{
-				Type:              "veth",
-				Address:           ipAddr,
-				Bridge:            vethBridge,
-				Gateway:           vethGateway,
-				Mtu:               1500,
-				Name:              "eth0",
-				HostInterfaceName: hostName,
-			},
+			veth,
+		}
+	}
+	return config, nil
+}
+
+//host path of a path of the container, following its bind mounts (e.g: the data directory)
+func hostPath(rootfs string, mounts []*configs.Mount, p string) string {
+	p = path.Join("/", p)
+	for _, m := range mounts {
+		if m.Device == "bind" && (p == m.Destination || strings.HasPrefix(p, m.Destination+"/")) {
+			return path.Join(m.Source, strings.TrimPrefix(p, m.Destination))
 		}
 	}
-	return config
+	return path.Join(rootfs, p)
 }
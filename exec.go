@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"os"
+	"syscall"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/libcontainer"
+	"github.com/docker/libcontainer/utils"
+)
+
+func execAction(c *cli.Context) {
+	args := c.Args()
+	if len(args) > 1 && args[1] == "--" {
+		args = append(args[:1], args[2:]...)
+	}
+	if len(args) < 2 {
+		log.Fatal("usage: sc-redis exec <uid|ip|ip:port> [--] command [args...]")
+	}
+
+	reg, err := newRegistry(c.GlobalString("state-dir"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ri, err := findInstance(reg, c.GlobalString("working_dir"), args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//joins the namespaces of the running container
+	process := &libcontainer.Process{
+		Args:   args[1:],
+		Env:    []string{"PATH=/usr/local/bin:/usr/local/sbin:/usr/bin:/usr/sbin:/bin:/sbin", "HOME=/root"},
+		User:   "root",
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	go handleSignals(process)
+
+	if err := ri.container.Start(process); err != nil {
+		log.Fatal(err)
+	}
+	status, err := process.Wait()
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(utils.ExitStatus(status.Sys().(syscall.WaitStatus)))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+const (
+	healthStarting  = "starting"  //redis-server never answered yet
+	healthLoading   = "loading"   //redis-server answers but is loading its dataset, live but not ready
+	healthReady     = "ready"     //redis-server answers PONG
+	healthUnhealthy = "unhealthy" //redis-server stopped answering
+
+	//exit status of sc-redis when redis-server is killed after failing its health checks (--health-kill)
+	exitUnhealthy = 3
+)
+
+//last health check result of an instance, recorded in the registry
+type healthStatus struct {
+	Status    string    `json:"status"`
+	Failures  int       `json:"failures"` //consecutive failed checks
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+//healthChecker periodically sends PING to redis-server. An instance is live when redis-server answers and
+//ready when it answers PONG. After retries consecutive failures of a live instance it is recorded unhealthy,
+//and reported on unhealthy when kill is set
+type healthChecker struct {
+	instance  *instance
+	reg       *registry
+	interval  time.Duration
+	timeout   time.Duration
+	retries   int
+	kill      bool
+	readyFile string
+	unhealthy chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	log       *logger
+}
+
+func newHealthChecker(i *instance, reg *registry, c *cli.Context, log *logger) (*healthChecker, error) {
+	h := &healthChecker{
+		instance:  i,
+		reg:       reg,
+		interval:  c.GlobalDuration("health-interval"),
+		timeout:   c.GlobalDuration("health-timeout"),
+		retries:   c.GlobalInt("health-retries"),
+		kill:      c.GlobalBool("health-kill"),
+		readyFile: c.GlobalString("ready-file"),
+		unhealthy: make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		log:       log,
+	}
+	if h.interval <= 0 {
+		return nil, fmt.Errorf("invalid --health-interval %s, expecting a positive duration", h.interval)
+	}
+	if h.timeout <= 0 {
+		return nil, fmt.Errorf("invalid --health-timeout %s, expecting a positive duration", h.timeout)
+	}
+	if h.retries < 1 {
+		return nil, fmt.Errorf("invalid --health-retries %d, expecting at least 1", h.retries)
+	}
+	return h, nil
+}
+
+func (h *healthChecker) run() {
+	defer close(h.done)
+	h.update(&healthStatus{Status: healthStarting})
+	defer h.setReady(false)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.check()
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//stops the checks and waits for the last one to complete
+func (h *healthChecker) shutdown() {
+	close(h.stop)
+	<-h.done
+}
+
+func (h *healthChecker) check() {
+	previous := h.instance.Health
+	status := &healthStatus{Status: previous.Status, LastCheck: time.Now()}
+
+	err := ping(h.instance, h.timeout)
+	switch {
+	case err == nil:
+		status.Status = healthReady
+	case strings.HasPrefix(err.Error(), "LOADING"):
+		status.Status = healthLoading
+		status.LastError = err.Error()
+	default:
+		status.Failures = previous.Failures + 1
+		status.LastError = err.Error()
+		//failures only count once redis-server answered, it may take a while to start
+		if previous.Status != healthStarting && status.Failures >= h.retries {
+			status.Status = healthUnhealthy
+		}
+	}
+
+	if status.Status != previous.Status {
+		h.log.Println("redis-server", status.Status)
+		if status.Status == healthUnhealthy {
+			h.log.Println("health check failed", status.Failures, "times:", status.LastError)
+		}
+		if status.Status == healthUnhealthy && h.kill {
+			select {
+			case h.unhealthy <- struct{}{}:
+			default:
+			}
+		}
+	}
+	h.setReady(status.Status == healthReady)
+	h.update(status)
+}
+
+func (h *healthChecker) update(status *healthStatus) {
+	changed := h.instance.Health == nil || h.instance.Health.Status != status.Status ||
+		h.instance.Health.Failures != status.Failures
+	h.instance.Health = status
+	if changed {
+		if err := h.reg.save(h.instance); err != nil {
+			h.log.Println("failed to record health status:", err)
+		}
+	}
+}
+
+//creates the ready file when redis-server is ready, removes it otherwise
+func (h *healthChecker) setReady(ready bool) {
+	if h.readyFile == "" {
+		return
+	}
+	if ready {
+		if _, err := os.Stat(h.readyFile); os.IsNotExist(err) {
+			ioutil.WriteFile(h.readyFile, []byte(h.instance.UID+"\n"), 0644)
+		}
+	} else {
+		os.Remove(h.readyFile)
+	}
+}
+
+//sends PING (after AUTH if requirepass is set) and expects PONG
+func ping(i *instance, timeout time.Duration) error {
+	client, err := dialInstance(i, timeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	reply, err := client.do("PING")
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected PING reply %v", reply)
+	}
+	return nil
+}
+
+//prints the health status recorded by the host sc-redis process, exits with 0 if the instance is ready, 1 otherwise
+func healthAction(c *cli.Context) {
+	ref := c.Args().First()
+	if ref == "" {
+		log.Fatal("usage: sc-redis health <uid|ip|ip:port>")
+	}
+	reg, err := newRegistry(c.GlobalString("state-dir"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ri, err := findInstance(reg, c.GlobalString("working_dir"), ref)
+	if err != nil {
+		log.Fatal(err)
+	}
+	status := ri.Health
+	if status == nil {
+		status = &healthStatus{Status: "unknown"}
+	}
+
+	if c.String("format") == "json" {
+		if err := json.NewEncoder(os.Stdout).Encode(status); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		fmt.Printf("%s %s", ri.UID, status.Status)
+		if !status.LastCheck.IsZero() {
+			fmt.Printf(" (last check %s ago", time.Since(status.LastCheck)/time.Second*time.Second)
+			if status.LastError != "" {
+				fmt.Printf(", %d failure(s): %s", status.Failures, status.LastError)
+			}
+			fmt.Printf(")")
+		}
+		fmt.Println()
+	}
+	if status.Status != healthReady {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libcontainer"
+	"github.com/docker/libcontainer/cgroups"
+)
+
+//maximum length of instance names
+const maxNameLength = 64
+
+//instance names (--name) are used as uid: container id, cgroup and rootfs directory name.
+//Topology names and the names of their instances also match it
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+func validateName(name string) error {
+	if len(name) > maxNameLength || !nameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid name %q, expecting at most %d letters, digits, _ and -, starting with a letter or a digit", name, maxNameLength)
+	}
+	return nil
+}
+
+//checks the name is not the uid of a live instance, registered or found under workingDir and in the sc-redis cgroup
+func checkNameAvailable(reg *registry, workingDir, name string) error {
+	if i, err := reg.load(name); err == nil && i.alive() {
+		return fmt.Errorf("name %s is already in use by a running instance (host pid %d)", name, i.Pid)
+	}
+	instances, err := findInstances(reg, workingDir)
+	if err != nil {
+		return err
+	}
+	for _, ri := range instances {
+		if ri.UID == name {
+			return fmt.Errorf("name %s is already in use by a running container", name)
+		}
+	}
+	return nil
+}
+
+//instance with its live libcontainer container
+type runningInstance struct {
+	*instance
+	container libcontainer.Container
+}
+
+//host path of a path of the container
+func (ri *runningInstance) hostPath(p string) string {
+	return hostPath(ri.Rootfs, ri.container.Config().Mounts, p)
+}
+
+//the factory root of a container is its rootfs (see start)
+func loadContainer(rootfs, uid string) (libcontainer.Container, error) {
+	factory, err := libcontainer.New(rootfs)
+	if err != nil {
+		return nil, err
+	}
+	return factory.Load(uid)
+}
+
+//finds running instances looking at the registry, the libcontainer states under workingDir
+//and the sc-redis cgroup parent. Stale registry entries are removed
+func findInstances(reg *registry, workingDir string) ([]*runningInstance, error) {
+	workingDir, err := filepath.Abs(workingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]*instance{}
+	registered, err := reg.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range registered {
+		known[i.UID] = i
+	}
+
+	//containers not (or no longer) in the registry
+	var candidates []string
+	for _, uid := range append(stateUIDs(workingDir), cgroupUIDs()...) {
+		if _, ok := known[uid]; !ok {
+			known[uid] = &instance{UID: uid, Rootfs: path.Join(workingDir, uid)}
+			candidates = append(candidates, uid)
+		}
+	}
+
+	var instances []*runningInstance
+	for _, i := range registered {
+		ri, err := inspectInstance(i)
+		if err != nil {
+			if !i.alive() {
+				reg.remove(i.UID)
+			}
+			continue
+		}
+		instances = append(instances, ri)
+	}
+	for _, uid := range candidates {
+		if ri, err := inspectInstance(known[uid]); err == nil {
+			instances = append(instances, ri)
+		}
+	}
+	return instances, nil
+}
+
+//finds the running instance matching the given uid, ip, ip:port or unix socket path
+func findInstance(reg *registry, workingDir, ref string) (*runningInstance, error) {
+	instances, err := findInstances(reg, workingDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, ri := range instances {
+		if ri.UID == ref || (ri.IP != "" && ri.IP == ref) || (ri.IPv6 != "" && ri.IPv6 == ref) || (ri.Socket != "" && ri.Socket == ref) || ri.redisAddr() == ref {
+			return ri, nil
+		}
+	}
+	return nil, fmt.Errorf("no running instance matching %s", ref)
+}
+
+//loads the container of the instance and fills what the registry may not know
+func inspectInstance(i *instance) (*runningInstance, error) {
+	container, err := loadContainer(i.Rootfs, i.UID)
+	if err != nil {
+		return nil, err
+	}
+	status, err := container.Status()
+	if err != nil {
+		return nil, err
+	}
+	if status != libcontainer.Running && status != libcontainer.Paused {
+		return nil, fmt.Errorf("container %s is not running", i.UID)
+	}
+	state, err := container.State()
+	if err != nil {
+		return nil, err
+	}
+	i.InitPid = state.InitProcessPid
+
+	if i.IP == "" && i.IPv6 == "" {
+		for _, n := range container.Config().Networks {
+			if n.Type == "veth" {
+				i.IP = strings.Split(n.Address, "/")[0]
+				i.IPv6 = strings.Split(n.IPv6Address, "/")[0]
+			}
+		}
+	}
+	if i.Config == nil {
+		if data, err := ioutil.ReadFile(path.Join(i.Rootfs, "etc", "redis.conf")); err == nil {
+			i.Config = splitConf(string(data))
+			i.Port = redisPort(i.Config)
+			if socket := directiveValue(i.Config, "unixsocket"); socket != "" && i.Port == 0 {
+				for _, m := range container.Config().Mounts {
+					if m.Destination == containerSocketDir {
+						i.Socket = path.Join(m.Source, path.Base(socket))
+					}
+				}
+			}
+		}
+	}
+	if i.StartedAt.IsZero() {
+		if fi, err := os.Stat(path.Join(i.Rootfs, i.UID, "state.json")); err == nil {
+			i.StartedAt = fi.ModTime()
+		}
+	}
+	return &runningInstance{instance: i, container: container}, nil
+}
+
+//uids of the libcontainer states found under workingDir (<workingDir>/<uid>/<uid>/state.json)
+func stateUIDs(workingDir string) []string {
+	files, err := ioutil.ReadDir(workingDir)
+	if err != nil {
+		return nil
+	}
+	var uids []string
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(path.Join(workingDir, f.Name(), f.Name(), "state.json")); err == nil {
+			uids = append(uids, f.Name())
+		}
+	}
+	return uids
+}
+
+//uids of the cgroups created under the sc-redis parent
+func cgroupUIDs() []string {
+	parent, err := cgroupPath("")
+	if err != nil {
+		return nil
+	}
+	dirs, err := ioutil.ReadDir(parent)
+	if err != nil {
+		return nil
+	}
+	var uids []string
+	for _, d := range dirs {
+		if d.IsDir() {
+			uids = append(uids, d.Name())
+		}
+	}
+	return uids
+}
+
+//memory cgroup directory of the given container, the sc-redis parent if uid == ""
+func cgroupPath(uid string) (string, error) {
+	mnt, err := cgroups.FindCgroupMountpoint("memory")
+	if err != nil {
+		return "", err
+	}
+	return path.Join(mnt, cgroupParent, uid), nil
+}
+
+//memory currently used by the container, read from its cgroup
+func memoryUsage(uid string) (int64, error) {
+	dir, err := cgroupPath(uid)
+	if err != nil {
+		return 0, err
+	}
+	data, err := ioutil.ReadFile(path.Join(dir, "memory.usage_in_bytes"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"path"
+	"strings"
 	"sync"
 	"syscall"
 	"testing"
@@ -70,12 +74,331 @@ func Test_bridge(t *testing.T) {
 	fmt.Println("done")
 }
 
+func Test_invalidIP(t *testing.T) {
+	fmt.Printf("with invalid ip ... ")
+	for _, ip := range []string{"172.18.1.1", "172.18.0.0", "172.18.255.255", "172.19.0.2", "foo"} {
+		b := newBinary(ip + ":6379")
+		if err := b.start("-i", ip); err == nil {
+			t.Fatalf("%s: expecting an error", ip)
+		}
+	}
+	fmt.Println("done")
+}
+
+func Test_ipConflict(t *testing.T) {
+	fmt.Printf("with conflicting ip ... ")
+	uid := launchDetached(t, newBinary("172.18.0.2:6379"), "-i", "172.18.0.2")
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run()
+	b := newBinary("172.18.0.2:6379")
+	if err := b.start("-i", "172.18.0.2"); err == nil {
+		t.Fatal("expecting an error")
+	}
+	if !strings.Contains(string(b.stderr), uid) {
+		b.printOutput()
+		t.Fatalf("expecting the error to report %s", uid)
+	}
+	fmt.Println("done")
+}
+
+func Test_customBridge(t *testing.T) {
+	fmt.Printf("with custom bridge ... ")
+	launch(t, newBinary("10.99.0.10:6379"), "--bridge", "scredistest0", "--subnet", "10.99.0.0/24", "-i", "10.99.0.10")
+	exec.Command("ip", "link", "delete", "scredistest0", "type", "bridge").Run()
+	if err := newBinary("").start("--subnet", "fd00:99::/64", "-i", "auto"); err == nil {
+		t.Fatal("IPv6 --subnet accepted")
+	}
+	if err := newBinary("").start("--ipv6-subnet", "10.99.0.0/24", "--ipv6", "10.99.0.10"); err == nil {
+		t.Fatal("IPv4 --ipv6-subnet accepted")
+	}
+	fmt.Println("done")
+}
+
+func Test_ipv6(t *testing.T) {
+	fmt.Printf("with IPv6 ... ")
+	launch(t, newBinary("[fd00:172:18::22]:6379"), "--ipv6", "fd00:172:18::22")
+	fmt.Println("done")
+}
+
+func Test_dualStack(t *testing.T) {
+	fmt.Printf("with dual stack ... ")
+	launch(t, newBinary("[fd00:172:18::23]:6379"), "-i", "172.18.5.25", "--ipv6", "fd00:172:18::23")
+	fmt.Println("done")
+}
+
+func Test_publish(t *testing.T) {
+	fmt.Printf("with published port ... ")
+	//the bridge gateway is a local address of the host
+	launch(t, newBinary("172.18.1.1:16379"), "-i", "172.18.5.24", "-p", "16379:6379")
+	out, err := exec.Command("iptables", "-t", "nat", "-S").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "172.18.5.24") {
+		t.Fatalf("iptables rules not removed: %s", string(out))
+	}
+	fmt.Println("done")
+}
+
+func Test_autoIP(t *testing.T) {
+	fmt.Printf("with auto ip ... ")
+	var uids []string
+	defer func() {
+		for _, uid := range uids {
+			exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run()
+		}
+	}()
+	reg, err := newRegistry("/var/run/sc-redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		out, err := exec.Command("sc-redis", "-w", os.TempDir(), "--detach", "-i", "auto").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		uid := strings.TrimSpace(string(out))
+		uids = append(uids, uid)
+		instance, err := reg.load(uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ips[instance.IP] {
+			t.Fatalf("ip %s allocated twice", instance.IP)
+		}
+		ips[instance.IP] = true
+		if err := newBinary(instance.IP + ":6379").waitUntilRunning(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fmt.Println("done")
+}
+
 func Test_config(t *testing.T) {
 	fmt.Printf("with net bridge and config ... ")
 	launch(t, newBinary("172.18.5.66:6381"), "-i", "172.18.5.66", "-c", "port 6381")
 	fmt.Println("done")
 }
 
+func Test_invalidConfig(t *testing.T) {
+	fmt.Printf("with invalid config ... ")
+	for _, conf := range []string{"daemonize yes", "port abc", "maxmemory-policy foo", "unknown-directive 1"} {
+		b := newBinary("127.0.0.1:6379")
+		if err := b.start("-c", conf); err == nil {
+			t.Fatalf("%s: expecting an error", conf)
+		}
+		if !strings.Contains(string(b.stderr), "invalid redis directive") {
+			b.printOutput()
+			t.Fatalf("%s: expecting a validation error", conf)
+		}
+	}
+	fmt.Println("done")
+}
+
+func Test_configFile(t *testing.T) {
+	fmt.Printf("with config file ... ")
+	file := path.Join(os.TempDir(), "sc-redis-test.yml")
+	conf := "redis:\n  - port 6382\nnetwork:\n  ip: 172.18.5.67\nlimits:\n  memory: 64m\n"
+	if err := ioutil.WriteFile(file, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	launch(t, newBinary("172.18.5.67:6382"), "-f", file)
+	fmt.Println("done")
+}
+
+func Test_dataDir(t *testing.T) {
+	fmt.Printf("with data directory ... ")
+	dataDir := path.Join(os.TempDir(), "sc-redis-data")
+	defer os.RemoveAll(dataDir)
+	launch(t, newBinary("127.0.0.1:6379"), "-d", dataDir)
+	//redis saves on SIGTERM when save points are configured
+	if _, err := os.Stat(path.Join(dataDir, "dump.rdb")); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("done")
+}
+
+func Test_limits(t *testing.T) {
+	fmt.Printf("with resource limits ... ")
+	launch(t, newBinary("127.0.0.1:6379"), "-m", "64m", "--cpu-shares", "512")
+	fmt.Println("done")
+}
+
+func Test_detach(t *testing.T) {
+	fmt.Printf("detached ... ")
+	uid := launchDetached(t, newBinary("127.0.0.1:6390"), "-c", "port 6390")
+	reg, err := newRegistry("/var/run/sc-redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := reg.load(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Port != 6390 {
+		t.Fatalf("expected port 6390, got %d", i.Port)
+	}
+	if err := syscall.Kill(i.Pid, syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("done")
+}
+
+func Test_ps(t *testing.T) {
+	fmt.Printf("ps ... ")
+	uid := launchDetached(t, newBinary("172.18.5.23:6379"), "-i", "172.18.5.23")
+	out, err := exec.Command("sc-redis", "-w", os.TempDir(), "ps", "-f", "json").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []*psEntry
+	if err := json.Unmarshal(out, &entries); err != nil {
+		t.Fatal(err)
+	}
+	var found *psEntry
+	for _, e := range entries {
+		if e.UID == uid {
+			found = e
+		}
+	}
+	if found == nil || found.IP != "172.18.5.23" || found.Port != 6379 {
+		t.Fatalf("%s not listed properly: %s", uid, string(out))
+	}
+	if err := syscall.Kill(found.Pid, syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("done")
+}
+
+func Test_stop(t *testing.T) {
+	fmt.Printf("stop ... ")
+	uid := launchDetached(t, newBinary("127.0.0.1:6391"), "-c", "port 6391, requirepass foobar")
+	if err := exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(os.TempDir(), uid)); !os.IsNotExist(err) {
+		t.Fatalf("rootfs of %s not removed", uid)
+	}
+	fmt.Println("done")
+}
+
+func Test_quotedPassword(t *testing.T) {
+	fmt.Printf("quoted password ... ")
+	uid := launchDetached(t, newBinary("127.0.0.1:6405"), "-c", `port 6405, requirepass "foo bar"`)
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run()
+	var err error
+	for i := 0; i < 30; i++ {
+		if err = exec.Command("sc-redis", "-w", os.TempDir(), "health", uid).Run(); err == nil {
+			break
+		}
+		time.Sleep(1 * time.Second)
+	}
+	if err != nil {
+		t.Fatalf("instance not ready, the health check does not authenticate: %v", err)
+	}
+	if err := exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("done")
+}
+
+func Test_exec(t *testing.T) {
+	fmt.Printf("exec ... ")
+	uid := launchDetached(t, newBinary("127.0.0.1:6392"), "-c", "port 6392")
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run()
+	out, err := exec.Command("sc-redis", "-w", os.TempDir(), "exec", uid, "--", "cat", "/etc/redis.conf").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "port 6392") {
+		t.Fatalf("unexpected redis.conf: %s", string(out))
+	}
+	err = exec.Command("sc-redis", "-w", os.TempDir(), "exec", uid, "--", "cat", "/does/not/exist").Run()
+	if e, ok := err.(*exec.ExitError); !ok || e.Success() {
+		t.Fatalf("expected a failed exit status, got %v", err)
+	}
+	fmt.Println("done")
+}
+
+func Test_mergedConfig(t *testing.T) {
+	fmt.Printf("merged config ... ")
+	uid := launchDetached(t, newBinary("127.0.0.1:6393"), "-c", "port 6393, save 60 1000")
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run()
+	out, err := exec.Command("sc-redis", "-w", os.TempDir(), "exec", uid, "--", "cat", "/etc/redis.conf").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var port, save int
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "port ") {
+			port++
+		}
+		if strings.HasPrefix(line, "save ") {
+			save++
+		}
+	}
+	if port != 1 || save != 1 {
+		t.Fatalf("expecting 1 port and 1 save directive, got %d and %d", port, save)
+	}
+	fmt.Println("done")
+}
+
+func Test_unixSocket(t *testing.T) {
+	fmt.Printf("with unix socket ... ")
+	dir := path.Join(os.TempDir(), "sc-redis-socket")
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "redis.sock")
+	out, err := exec.Command("sc-redis", "-w", os.TempDir(), "--detach", "--unix-socket", socket, "--unix-socket-owner", "1000:1000",
+		"--restart", "on-failure:1").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := strings.TrimSpace(string(out))
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run()
+
+	var fi os.FileInfo
+	for i := 0; i < 30; i++ {
+		if fi, err = os.Stat(socket); err == nil && fi.Sys().(*syscall.Stat_t).Uid == 1000 {
+			break
+		}
+		time.Sleep(1 * time.Second)
+	}
+	if err != nil || fi.Sys().(*syscall.Stat_t).Uid != 1000 {
+		t.Fatalf("socket %s not created or not owned by 1000: %v", socket, err)
+	}
+	c, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if _, err := net.DialTimeout("tcp", "127.0.0.1:6379", 1*time.Second); err == nil {
+		t.Fatal("redis should not listen on TCP")
+	}
+
+	//the socket created by the restarted redis-server gets the owner too
+	reg, err := newRegistry("/var/run/sc-redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed, err := reg.load(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ino := fi.Sys().(*syscall.Stat_t).Ino
+	syscall.Kill(crashed.InitPid, syscall.SIGKILL)
+	for i := 0; i < 30; i++ {
+		time.Sleep(1 * time.Second)
+		if fi, err = os.Stat(socket); err == nil && fi.Sys().(*syscall.Stat_t).Ino != ino && fi.Sys().(*syscall.Stat_t).Uid == 1000 {
+			break
+		}
+	}
+	if err != nil || fi.Sys().(*syscall.Stat_t).Ino == ino || fi.Sys().(*syscall.Stat_t).Uid != 1000 {
+		t.Fatalf("socket %s not recreated or not owned by 1000 after a restart: %v", socket, err)
+	}
+	fmt.Println("done")
+}
+
 func Test_multi(t *testing.T) {
 	fmt.Println("spawning 10 instances ...")
 	var wg sync.WaitGroup
@@ -108,6 +431,368 @@ func Test_multiBridge(t *testing.T) {
 	fmt.Println("done")
 }
 
+func Test_health(t *testing.T) {
+	fmt.Printf("health ... ")
+	readyFile := path.Join(os.TempDir(), "sc-redis-health.ready")
+	os.Remove(readyFile)
+	b := newBinary("127.0.0.1:6396")
+	exited := make(chan error, 1)
+	go func() {
+		exited <- b.start("-c", "port 6396, requirepass foobar", "--ready-file", readyFile,
+			"--health-interval", "1s", "--health-retries", "2", "--health-kill")
+	}()
+	var uid []byte
+	for i := 0; i < 30 && len(uid) == 0; i++ {
+		time.Sleep(1 * time.Second)
+		uid, _ = ioutil.ReadFile(readyFile)
+	}
+	if len(uid) == 0 {
+		b.stop()
+		t.Fatal("ready file not created")
+	}
+	if err := exec.Command("sc-redis", "-w", os.TempDir(), "health", strings.TrimSpace(string(uid))).Run(); err != nil {
+		b.stop()
+		t.Fatalf("expected a ready instance, got %v", err)
+	}
+
+	//a stopped redis-server stops answering PING and gets killed with --health-kill
+	data, err := ioutil.ReadFile(path.Join("/var/run/sc-redis", strings.TrimSpace(string(uid))+".json"))
+	if err != nil {
+		b.stop()
+		t.Fatal(err)
+	}
+	var i instance
+	json.Unmarshal(data, &i)
+	syscall.Kill(i.InitPid, syscall.SIGSTOP)
+	select {
+	case err := <-exited:
+		e, ok := err.(*exec.ExitError)
+		if !ok || e.Sys().(syscall.WaitStatus).ExitStatus() != exitUnhealthy {
+			t.Fatalf("expected exit status %d, got %v", exitUnhealthy, err)
+		}
+	case <-time.After(30 * time.Second):
+		b.stop()
+		t.Fatal("unhealthy redis-server not killed")
+	}
+	if _, err := os.Stat(readyFile); !os.IsNotExist(err) {
+		t.Fatal("ready file not removed")
+	}
+	if err := exec.Command("sc-redis", "-w", os.TempDir(), "-c", "port 6396", "--health-interval", "0").Run(); err == nil {
+		t.Fatal("--health-interval 0 accepted")
+	}
+	fmt.Println("done")
+}
+
+func Test_restart(t *testing.T) {
+	fmt.Printf("restart ... ")
+	b := newBinary("127.0.0.1:6397")
+	uid := launchDetached(t, b, "-c", "port 6397", "--restart", "on-failure:1")
+	load := func() *instance {
+		data, err := ioutil.ReadFile(path.Join("/var/run/sc-redis", uid+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var i instance
+		if err := json.Unmarshal(data, &i); err != nil {
+			t.Fatal(err)
+		}
+		return &i
+	}
+	crashed := load()
+	syscall.Kill(crashed.InitPid, syscall.SIGKILL)
+	var restarted *instance
+	for i := 0; i < 30; i++ {
+		time.Sleep(1 * time.Second)
+		if restarted = load(); restarted.Restarts == 1 && restarted.InitPid != crashed.InitPid {
+			break
+		}
+	}
+	if restarted.Restarts != 1 {
+		t.Fatalf("redis-server of %s not restarted", uid)
+	}
+	if err := b.waitUntilRunning(); err != nil {
+		t.Fatal(err)
+	}
+	//stopped instances are not restarted
+	if err := exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if processAlive(restarted.Pid) {
+		t.Fatalf("%s still running after stop", uid)
+	}
+	fmt.Println("done")
+}
+
+func Test_metrics(t *testing.T) {
+	fmt.Printf("metrics ... ")
+	uid := launchDetached(t, newBinary("127.0.0.1:6398"), "-c", "port 6398", "--metrics-addr", "127.0.0.1:9398")
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run()
+	resp, err := http.Get("http://127.0.0.1:9398/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, sample := range []string{
+		`redis_up{uid="` + uid + `"} 1`,
+		`sc_redis_restarts_total{uid="` + uid + `"} 0`,
+		"redis_connected_clients{",
+		"sc_redis_container_memory_usage_bytes{",
+	} {
+		if !strings.Contains(string(body), sample) {
+			t.Fatalf("%s not exported: %s", sample, string(body))
+		}
+	}
+	fmt.Println("done")
+}
+
+func Test_jsonLogs(t *testing.T) {
+	fmt.Printf("json logs ... ")
+	logFile := path.Join(os.TempDir(), "sc-redis-test.log")
+	os.Remove(logFile)
+	defer os.Remove(logFile)
+	launch(t, newBinary("127.0.0.1:6399"), "-c", "port 6399", "--log-format", "json", "--log-file", logFile)
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var r logRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("invalid log record %q: %v", line, err)
+		}
+		if r.UID == "" || r.Level == "" {
+			t.Fatalf("incomplete log record %q", line)
+		}
+		sources[r.Source] = true
+	}
+	for _, source := range []string{"host", "container", "redis"} {
+		if !sources[source] {
+			t.Fatalf("no %s log record in %s", source, string(data))
+		}
+	}
+	fmt.Println("done")
+}
+
+func Test_snapshot(t *testing.T) {
+	fmt.Printf("snapshot and restore ... ")
+	rdb := path.Join(os.TempDir(), "sc-redis-snapshot.rdb")
+	defer os.Remove(rdb)
+
+	uid := launchDetached(t, newBinary("127.0.0.1:6400"), "-c", "port 6400")
+	client, err := dialRedis("tcp", "127.0.0.1:6400", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.do("SET", "foo", "bar")
+	client.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exec.Command("sc-redis", "-w", os.TempDir(), "snapshot", uid, "-o", rdb).Run()
+	exec.Command("sc-redis", "-w", os.TempDir(), "stop", "--nosave", uid).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uid = launchDetached(t, newBinary("127.0.0.1:6401"), "-c", "port 6401", "--restore", rdb)
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run()
+	client, err = dialRedis("tcp", "127.0.0.1:6401", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if value, err := client.do("GET", "foo"); err != nil || value != "bar" {
+		t.Fatalf("expected foo to be restored, got %v (%v)", value, err)
+	}
+	fmt.Println("done")
+}
+
+func Test_backups(t *testing.T) {
+	fmt.Printf("scheduled backups ... ")
+	dir := path.Join(os.TempDir(), "sc-redis-backups")
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	uid := launchDetached(t, newBinary("127.0.0.1:6402"), "-c", "port 6402",
+		"--backup-interval", "2s", "--backup-dir", dir, "--backup-keep", "2")
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", uid).Run()
+	time.Sleep(15 * time.Second)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 backups, got %d", len(files))
+	}
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), uid+"-") {
+			t.Fatalf("unexpected backup %s", f.Name())
+		}
+		if err := verifyRDB(path.Join(dir, f.Name())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exec.Command("sc-redis", "-w", os.TempDir(), "-c", "port 6402", "--backup-interval", "1s",
+		"--backup-dir", dir, "--backup-timeout", "0").Run(); err == nil {
+		t.Fatal("--backup-timeout 0 accepted")
+	}
+	fmt.Println("done")
+}
+
+func Test_replicaOf(t *testing.T) {
+	fmt.Printf("replica ... ")
+	master := launchDetached(t, newBinary("172.18.5.30:6379"), "-i", "172.18.5.30", "-c", "requirepass foobar")
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", master).Run()
+	replica := launchDetached(t, newBinary("172.18.5.31:6379"), "-i", "172.18.5.31", "--replica-of", master)
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", replica).Run()
+
+	client, err := dialRedis("tcp", "172.18.5.30:6379", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.do("AUTH", "foobar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.do("SET", "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err = dialRedis("tcp", "172.18.5.31:6379", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var value interface{}
+	for i := 0; i < 30 && value != "bar"; i++ {
+		time.Sleep(1 * time.Second)
+		value, _ = client.do("GET", "foo")
+	}
+	if value != "bar" {
+		t.Fatalf("foo not replicated, got %v", value)
+	}
+	fmt.Println("done")
+}
+
+func Test_sentinel(t *testing.T) {
+	fmt.Printf("sentinel ... ")
+	master := launchDetached(t, newBinary("172.18.5.40:6379"), "-i", "172.18.5.40")
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", master).Run()
+	sentinel := launchDetached(t, newBinary("172.18.5.41:26379"), "-i", "172.18.5.41",
+		"sentinel", "--monitor", "mymaster="+master, "--quorum", "1")
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", sentinel).Run()
+
+	client, err := dialRedis("tcp", "172.18.5.41:26379", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	reply, err := client.do("SENTINEL", "get-master-addr-by-name", "mymaster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr, ok := reply.([]interface{}); !ok || len(addr) != 2 || addr[0] != "172.18.5.40" || addr[1] != "6379" {
+		t.Fatalf("unexpected master address %v", reply)
+	}
+	fmt.Println("done")
+}
+
+func Test_up(t *testing.T) {
+	fmt.Printf("up ... ")
+	manifest := path.Join(os.TempDir(), "sc_redis_topology.json")
+	err := ioutil.WriteFile(manifest, []byte(`{
+  "name": "test",
+  "instances": [
+    {"name": "replica", "network": {"ip": "172.18.5.51"}, "replication": {"replica_of": "master"}},
+    {"name": "master", "network": {"ip": "172.18.5.50"}, "redis": ["requirepass foobar"]}
+  ]
+}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(manifest)
+
+	out, err := exec.Command("sc-redis", "-w", os.TempDir(), "--detach", "up", "-f", manifest).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "down", "-f", manifest).Run()
+	if uids := strings.Fields(string(out)); len(uids) != 2 || uids[0] != "test_master" || uids[1] != "test_replica" {
+		t.Fatalf("unexpected uids %q", out)
+	}
+
+	client, err := dialRedis("tcp", "172.18.5.50:6379", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.do("AUTH", "foobar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.do("SET", "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	client, err = dialRedis("tcp", "172.18.5.51:6379", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var value interface{}
+	for i := 0; i < 30 && value != "bar"; i++ {
+		time.Sleep(1 * time.Second)
+		value, _ = client.do("GET", "foo")
+	}
+	if value != "bar" {
+		t.Fatalf("foo not replicated, got %v", value)
+	}
+
+	if err := exec.Command("sc-redis", "-w", os.TempDir(), "down", "-f", manifest).Run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range []string{"test_master", "test_replica"} {
+		if _, err := os.Stat(path.Join(os.TempDir(), uid)); !os.IsNotExist(err) {
+			t.Fatalf("rootfs of %s not removed", uid)
+		}
+	}
+	fmt.Println("done")
+}
+
+func Test_name(t *testing.T) {
+	fmt.Printf("name ... ")
+	uid := launchDetached(t, newBinary("127.0.0.1:6403"), "--name", "test_named", "-c", "port 6403")
+	defer exec.Command("sc-redis", "-w", os.TempDir(), "stop", "test_named").Run()
+	if uid != "test_named" {
+		t.Fatalf("expected uid test_named, got %s", uid)
+	}
+
+	out, err := exec.Command("sc-redis", "-w", os.TempDir(), "--detach", "--name", "test_named", "-c", "port 6404").CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "already in use") {
+		t.Fatalf("name reused: %s", out)
+	}
+	if err := exec.Command("sc-redis", "-w", os.TempDir(), "--name", "not/valid").Run(); err == nil {
+		t.Fatal("invalid name accepted")
+	}
+
+	if err := exec.Command("sc-redis", "-w", os.TempDir(), "stop", "test_named").Run(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("done")
+}
+
+//starts a detached instance and returns its uid once redis is reachable
+func launchDetached(t *testing.T, b *binary, args ...string) string {
+	args = append([]string{"-w", os.TempDir(), "--detach"}, args...)
+	out, err := exec.Command(b.name, args...).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.waitUntilRunning(); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
 func launch(t *testing.T, b *binary, args ...string) {
 	var (
 		errStart   error
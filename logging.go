@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/docker/pkg/units"
+)
+
+//redis-server log lines, "[pid] 18 Oct 12:00:00.123 * message" (2.8) or "pid:role 18 Oct 2026 12:00:00.123 * message" (3.0+)
+var redisLogRegexp = regexp.MustCompile(`^(?:\[(\d+)\]|(\d+):([MSCX])) \d{1,2} \w{3}(?: \d{4})? [\d:.]+ ([.\-*#]) (.*)$`)
+
+var (
+	redisLogLevels = map[string]string{".": "debug", "-": "debug", "*": "info", "#": "warning"}
+	redisLogRoles  = map[string]string{"M": "master", "S": "slave", "C": "child", "X": "sentinel"}
+)
+
+//structured log record emitted with --log-format=json
+type logRecord struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	UID     string    `json:"uid"`
+	Source  string    `json:"source"` //host, container or redis
+	Message string    `json:"message"`
+	Pid     int       `json:"pid,omitempty"`  //redis-server pid, inside the container
+	Role    string    `json:"role,omitempty"` //redis-server role: master, slave, child or sentinel
+}
+
+//logOutput is where the host sc-redis process and its instances log: stdout/stderr or a rotating log file,
+//as text or as json records
+type logOutput struct {
+	json bool
+	file io.Writer //nil for stdout/stderr
+
+	mu sync.Mutex
+}
+
+func newLogOutput(c *cli.Context) (*logOutput, error) {
+	o := &logOutput{}
+	switch format := c.GlobalString("log-format"); format {
+	case "text":
+	case "json":
+		o.json = true
+	default:
+		return nil, fmt.Errorf("invalid log format %s, expecting text or json", format)
+	}
+	if path := c.GlobalString("log-file"); path != "" {
+		maxSize, err := units.RAMInBytes(c.GlobalString("log-max-size"))
+		if err != nil || maxSize <= 0 {
+			return nil, fmt.Errorf("invalid log file size %s", c.GlobalString("log-max-size"))
+		}
+		if o.file, err = openRotatingFile(path, maxSize, c.GlobalInt("log-max-files")); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+//logger of the instance uid. Text lines of tagged loggers are prefixed with the uid, for instances sharing
+//the output of a single host sc-redis process
+func (o *logOutput) logger(uid string, tagged bool) *logger {
+	l := &logger{out: o, uid: uid, tagged: tagged, prefix: "[host] "}
+	if tagged {
+		l.prefix = "[host " + uid + "] "
+	}
+	l.Logger = log.New(l.hostWriter(), l.prefix, 0)
+	return l
+}
+
+func (o *logOutput) write(r *logRecord) {
+	r.Time = time.Now()
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	out := o.file
+	if out == nil {
+		out = os.Stdout
+	}
+	o.mu.Lock()
+	out.Write(append(data, '\n'))
+	o.mu.Unlock()
+}
+
+//logger routes the host sc-redis messages (embedded log.Logger), the container init and redis-server output
+//of an instance to the log output
+type logger struct {
+	*log.Logger
+	out    *logOutput
+	uid    string
+	tagged bool
+	prefix string //of the host messages
+}
+
+//logger of the host sc-redis process, set by start and up
+var hostLogger *logger
+
+//writer of the host sc-redis messages
+func (l *logger) hostWriter() io.Writer {
+	if l.out.json {
+		return &lineWriter{fn: l.hostLine}
+	}
+	if l.out.file != nil {
+		return l.out.file
+	}
+	return os.Stderr
+}
+
+//writer of the container process output, std being the stream used without log file
+func (l *logger) processWriter(std io.Writer) io.Writer {
+	if l.out.json {
+		return &lineWriter{fn: l.processLine}
+	}
+	if l.out.file != nil {
+		std = l.out.file
+	}
+	if l.tagged {
+		prefix := "[" + l.uid + "] "
+		return &lineWriter{fn: func(line string) {
+			io.WriteString(std, prefix+line+"\n")
+		}}
+	}
+	return std
+}
+
+func (l *logger) hostLine(line string) {
+	l.write(&logRecord{Level: "info", Source: "host", Message: strings.TrimPrefix(line, l.prefix)})
+}
+
+func (l *logger) processLine(line string) {
+	if strings.HasPrefix(line, "[container] ") {
+		l.write(&logRecord{Level: "info", Source: "container", Message: strings.TrimPrefix(line, "[container] ")})
+		return
+	}
+	l.write(parseRedisLogLine(line))
+}
+
+func (l *logger) write(r *logRecord) {
+	r.UID = l.uid
+	l.out.write(r)
+}
+
+//logs err and exits with status 1, as an error record with --log-format=json
+func logFatal(err error) {
+	if hostLogger != nil && hostLogger.out.json {
+		hostLogger.write(&logRecord{Level: "error", Source: "host", Message: err.Error()})
+		os.Exit(1)
+	}
+	log.Fatal(err)
+}
+
+//parses a redis-server log line, lines not following the redis log format (e.g: the startup logo) are kept as is
+func parseRedisLogLine(line string) *logRecord {
+	r := &logRecord{Level: "info", Source: "redis", Message: line}
+	m := redisLogRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return r
+	}
+	pid := m[1]
+	if pid == "" {
+		pid = m[2]
+	}
+	r.Pid, _ = strconv.Atoi(pid)
+	r.Role = redisLogRoles[m[3]]
+	r.Level = redisLogLevels[m[4]]
+	r.Message = m[5]
+	return r
+}
+
+//lineWriter calls fn for each complete line written
+type lineWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	fn  func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.buf.Next(i+1)), "\r\n")
+		if line != "" {
+			w.fn(line)
+		}
+	}
+	return len(p), nil
+}
+
+//rotatingFile is rotated when it would exceed maxSize: path is renamed path.1, path.1 path.2 and so on,
+//keeping at most maxFiles rotated files
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	return r, r.open()
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxFiles))
+	for i := r.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.maxFiles > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
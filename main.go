@@ -3,12 +3,16 @@ package main
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/codegangsta/cli"
 	"github.com/docker/libcontainer"
@@ -21,10 +25,17 @@ const (
 	libcontainerVersion = "b6cf7a6c8520fd21e75f8b3becec6dc355d844b0"
 	redisVersion        = "2.8.19"
 
-	//bridge
-	vethBridge  = "scredis0"
-	vethNetwork = "172.18.1.1/16"
-	vethGateway = "172.18.1.1"
+	//default bridge
+	defaultBridge  = "scredis0"
+	defaultSubnet  = "172.18.0.0/16"
+	defaultGateway = "172.18.1.1"
+
+	//default bridge IPv6 subnet
+	defaultIPv6Subnet  = "fd00:172:18::/64"
+	defaultIPv6Gateway = "fd00:172:18::1"
+
+	//set by a detaching sc-redis for its background child: the instance uid, or the topology name with up
+	uidEnv = "SC_REDIS_UID"
 )
 
 func init() {
@@ -40,8 +51,45 @@ func main() {
 	app.Usage = "self contained redis-server"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{Name: "config, c", Usage: "redis configuration, e.g: \"requirepass foobar, port 9999, ...\""},
-		cli.StringFlag{Name: "ip, i", Usage: "use the net namespace with the given ip address, format: 172.18.xxx.xxx"},
+		cli.StringFlag{Name: "config-file, f", Usage: "instance configuration file (json or yaml), flags take precedence over it"},
+		cli.StringFlag{Name: "name", Usage: "name of the instance, used as its uid in ps, stop, logs and metrics (default: random sc_redis_xxxxxxx)"},
+		cli.StringFlag{Name: "ip, i", Usage: "use the net namespace with the given ip address of the bridge subnet (172.18.xxx.xxx by default) or auto"},
+		cli.StringFlag{Name: "bridge", Usage: "bridge the container is attached to when using -i, created if needed (default: " + defaultBridge + ")"},
+		cli.StringFlag{Name: "subnet", Usage: "subnet of the bridge in CIDR format (default: " + defaultSubnet + ")"},
+		cli.StringFlag{Name: "gateway", Usage: "gateway address of the bridge subnet (default: " + defaultGateway + " or the first address of --subnet)"},
+		cli.StringFlag{Name: "ipv6", Usage: "use the net namespace with the given IPv6 address of the bridge IPv6 subnet, can be combined with -i"},
+		cli.StringFlag{Name: "ipv6-subnet", Usage: "IPv6 subnet of the bridge in CIDR format (default: " + defaultIPv6Subnet + ")"},
+		cli.StringFlag{Name: "ipv6-gateway", Usage: "IPv6 gateway address of the bridge (default: " + defaultIPv6Gateway + " or the first address of --ipv6-subnet)"},
+		cli.StringSliceFlag{Name: "publish, p", Value: &cli.StringSlice{}, Usage: "publish a container port on the host with -i, format: hostport:containerport"},
+		cli.StringFlag{Name: "replica-of", Usage: "start a replica of the given running instance (uid, ip or ip:port) or host:port"},
+		cli.StringFlag{Name: "master-auth", Usage: "password of the master (default: requirepass of the master instance)"},
 		cli.StringFlag{Name: "working_dir, w", Value: ".", Usage: "working directory where container are created"},
+		cli.StringFlag{Name: "memory, m", Usage: "memory limit, e.g: 512m, 1g (redis maxmemory is derived from it)"},
+		cli.StringFlag{Name: "memory-swap", Usage: "total memory + swap limit, -1 to disable swap limit"},
+		cli.IntFlag{Name: "cpu-shares", Usage: "CPU shares (relative weight)"},
+		cli.StringFlag{Name: "cpuset", Usage: "CPUs in which to allow execution, e.g: 0-3, 0,1"},
+		cli.StringFlag{Name: "data-dir, d", Usage: "host directory mounted as redis working directory, RDB/AOF files persist across runs"},
+		cli.StringFlag{Name: "unix-socket", Usage: "only listen on a unix socket created at the given host path, no TCP"},
+		cli.StringFlag{Name: "unix-socket-owner", Usage: "uid:gid owning the unix socket on the host"},
+		cli.StringFlag{Name: "unix-socket-perm", Usage: "octal permissions of the unix socket (default: 770)"},
+		cli.BoolFlag{Name: "detach", Usage: "run sc-redis in the background and print the container uid"},
+		cli.StringFlag{Name: "state-dir", Value: "/var/run/sc-redis", Usage: "directory where running instances are recorded"},
+		cli.DurationFlag{Name: "health-interval", Value: 5 * time.Second, Usage: "time between two health checks (PING) of redis-server"},
+		cli.DurationFlag{Name: "health-timeout", Value: 2 * time.Second, Usage: "time to wait for a health check reply"},
+		cli.IntFlag{Name: "health-retries", Value: 3, Usage: "consecutive failed health checks before redis-server is considered unhealthy"},
+		cli.BoolFlag{Name: "health-kill", Usage: "kill redis-server once it is unhealthy (then restarted according to --restart)"},
+		cli.StringFlag{Name: "restart", Value: "no", Usage: "restart policy when redis-server exits: no, on-failure[:max restarts] or always"},
+		cli.StringFlag{Name: "metrics-addr", Usage: "serve Prometheus metrics of the instance on the given address, e.g: 127.0.0.1:9121"},
+		cli.StringFlag{Name: "log-format", Value: "text", Usage: "output format of the host, container and redis-server logs, text or json"},
+		cli.StringFlag{Name: "log-file", Usage: "write logs to the given file, rotated according to --log-max-size and --log-max-files"},
+		cli.StringFlag{Name: "log-max-size", Value: "10m", Usage: "size of the log file before it is rotated"},
+		cli.IntFlag{Name: "log-max-files", Value: 5, Usage: "number of rotated log files to keep"},
+		cli.StringFlag{Name: "restore", Usage: "RDB file loaded by redis-server on start, copied to the data directory or to the container rootfs"},
+		cli.DurationFlag{Name: "backup-interval", Usage: "save the dataset to --backup-dir at this interval, e.g: 1h"},
+		cli.StringFlag{Name: "backup-dir", Usage: "host directory where backups are written as <uid>-<timestamp>.rdb"},
+		cli.IntFlag{Name: "backup-keep", Value: 7, Usage: "number of backups to keep, 0 to keep them all"},
+		cli.DurationFlag{Name: "backup-timeout", Value: defaultBGSaveTimeout, Usage: "time to wait for the BGSAVE of a backup to complete"},
+		cli.StringFlag{Name: "ready-file", Usage: "file created when redis-server is ready and removed when it is not"},
 	}
 	app.Commands = []cli.Command{
 		cli.Command{
@@ -49,13 +97,80 @@ func main() {
 			Usage:  "container init, should never be invoked manually",
 			Action: initAction,
 		},
+		cli.Command{
+			Name:      "ps",
+			ShortName: "list",
+			Usage:     "list running instances",
+			Action:    psAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "format, f", Value: "table", Usage: "output format, table or json"},
+			},
+		},
+		cli.Command{
+			Name:   "stop",
+			Usage:  "stop a running instance, e.g: sc-redis stop <uid|ip|ip:port>",
+			Action: stopAction,
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "nosave", Usage: "do not save the dataset on shutdown (SHUTDOWN NOSAVE)"},
+				cli.DurationFlag{Name: "timeout, t", Value: 10 * time.Second, Usage: "time to wait after each stop attempt before escalating"},
+			},
+		},
+		cli.Command{
+			Name:   "up",
+			Usage:  "launch the instances of a topology manifest, e.g: sc-redis up -f topology.yml",
+			Action: upAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "file, f", Usage: "topology manifest (json or yaml)"},
+			},
+		},
+		cli.Command{
+			Name:   "down",
+			Usage:  "stop the instances of a topology manifest, e.g: sc-redis down -f topology.yml",
+			Action: downAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "file, f", Usage: "topology manifest (json or yaml)"},
+				cli.BoolFlag{Name: "nosave", Usage: "do not save the datasets on shutdown (SHUTDOWN NOSAVE)"},
+				cli.DurationFlag{Name: "timeout, t", Value: 10 * time.Second, Usage: "time to wait after each stop attempt before escalating"},
+			},
+		},
+		cli.Command{
+			Name:            "exec",
+			Usage:           "run a command inside a running instance, e.g: sc-redis exec <uid> -- cat /etc/redis.conf",
+			Action:          execAction,
+			SkipFlagParsing: true,
+		},
+		cli.Command{
+			Name:   "snapshot",
+			Usage:  "save the dataset of a running instance to a RDB file, e.g: sc-redis snapshot <uid> -o dump.rdb",
+			Action: snapshotAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "output, o", Usage: "RDB file to write"},
+				cli.DurationFlag{Name: "timeout, t", Value: defaultBGSaveTimeout, Usage: "time to wait for BGSAVE to complete"},
+			},
+		},
+		cli.Command{
+			Name:   "sentinel",
+			Usage:  "run redis-server in sentinel mode, e.g: sc-redis -i 172.18.0.20 sentinel --monitor mymaster=<uid> --quorum 1",
+			Action: sentinelAction,
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{Name: "monitor", Value: &cli.StringSlice{}, Usage: "master to monitor, format: name=uid|ip:port"},
+				cli.IntFlag{Name: "quorum", Value: defaultQuorum, Usage: "sentinels needed to agree a master is down"},
+				cli.DurationFlag{Name: "down-after", Value: defaultDownAfter, Usage: "time a master must be unreachable to be considered down"},
+				cli.DurationFlag{Name: "failover-timeout", Value: defaultFailoverTimeout, Usage: "failover timeout"},
+				cli.IntFlag{Name: "parallel-syncs", Value: defaultParallelSyncs, Usage: "replicas reconfigured at the same time during a failover"},
+			},
+		},
+		cli.Command{
+			Name:   "health",
+			Usage:  "print the health of a running instance, exits with 0 if it is ready, e.g: sc-redis health <uid>",
+			Action: healthAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "format, f", Value: "table", Usage: "output format, table or json"},
+			},
+		},
 	}
 	app.Action = func(c *cli.Context) {
-		exit, err := start(c)
-		if err != nil {
-			log.Fatal(err)
-		}
-		os.Exit(exit)
+		run(c, nil)
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -81,13 +196,82 @@ func initAction(c *cli.Context) {
 	panic("This line should never been executed")
 }
 
-func start(c *cli.Context) (int, error) {
+//starts an instance, in the background with --detach. sentinel is the configuration given to the sentinel command
+func run(c *cli.Context, sentinel *sentinelConfig) {
+	var (
+		exit int
+		err  error
+	)
+	if c.GlobalBool("detach") && os.Getenv(uidEnv) == "" {
+		var uid string
+		if uid, err = newInstanceUID(c); err == nil {
+			exit, err = detach(c, uid, []string{uid})
+		}
+	} else {
+		exit, err = start(c, sentinel)
+	}
+	if err != nil {
+		logFatal(err)
+	}
+	os.Exit(exit)
+}
+
+func start(c *cli.Context, sentinel *sentinelConfig) (int, error) {
 	log.SetPrefix("[host] ")
 
-	uid, err := utils.GenerateRandomName("sc_redis_", 7)
+	reg, err := newRegistry(c.GlobalString("state-dir"))
+	if err != nil {
+		return 1, err
+	}
+
+	uid := os.Getenv(uidEnv)
+	if uid == "" {
+		uid, err = newInstanceUID(c)
+		if err != nil {
+			return 1, err
+		}
+	}
+
+	out, err := newLogOutput(c)
+	if err != nil {
+		return 1, err
+	}
+	hostLogger = out.logger(uid, false)
+	log.SetOutput(hostLogger.hostWriter())
+
+	conf, err := newInstanceConfig(c, sentinel)
+	if err != nil {
+		return 1, err
+	}
+	return runInstance(c, reg, uid, "", conf, nil, hostLogger)
+}
+
+//uid of a new instance: --name, checked to be valid and not in use, or a random sc_redis_ name
+func newInstanceUID(c *cli.Context) (string, error) {
+	name := c.GlobalString("name")
+	if name == "" {
+		return utils.GenerateRandomName("sc_redis_", 7)
+	}
+	if err := validateName(name); err != nil {
+		return "", err
+	}
+	reg, err := newRegistry(c.GlobalString("state-dir"))
 	if err != nil {
+		return "", err
+	}
+	return name, checkNameAvailable(reg, c.GlobalString("working_dir"), name)
+}
+
+//runs the instance uid in a container until redis-server exits and is not restarted, returns its exit status.
+//The instances of a topology run concurrently in the host sc-redis process, each logging with its own logger,
+//and are stopped when abort is closed
+func runInstance(c *cli.Context, reg *registry, uid, topology string, conf *instanceConfig, abort <-chan struct{}, log *logger) (int, error) {
+	//the cleanup below is keyed by uid, it must only run once the uid is ours
+	if err := reg.reserve(uid); err != nil {
 		return 1, err
 	}
+	//deferred first to unregister the instance once it is cleaned up
+	defer reg.remove(uid)
 
 	log.Println("pid", os.Getpid())
 	log.Println("container uid:", uid)
@@ -101,22 +285,127 @@ func start(c *cli.Context) (int, error) {
 		return 1, err
 	}
 
-	log.Println("writing redis configuration")
-	if err := writeRawRedisConf(path.Join(rootfs, "etc"), c.GlobalString("config")); err != nil {
+	if conf.Sentinel != nil && (c.GlobalString("restore") != "" || c.GlobalDuration("backup-interval") > 0) {
+		return 1, fmt.Errorf("sentinel has no dataset to restore or back up")
+	}
+	policy, err := parseRestartPolicy(c.GlobalString("restart"))
+	if err != nil {
 		return 1, err
 	}
 
-	ipAddr := c.GlobalString("ip")
-	if ipAddr != "" {
-		if err := setupNetBridge(); err != nil {
+	if dataDir := conf.Volumes.DataDir; dataDir != "" {
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return 1, err
+		}
+		if err := os.MkdirAll(path.Join(rootfs, containerDataDir), 0755); err != nil {
+			return 1, err
+		}
+		log.Println("data directory:", dataDir)
+	}
+	if socket := conf.UnixSocket.Path; socket != "" {
+		if err := os.MkdirAll(filepath.Dir(socket), 0755); err != nil {
+			return 1, err
+		}
+		if err := os.MkdirAll(path.Join(rootfs, containerSocketDir), 0755); err != nil {
+			return 1, err
+		}
+		if err := removeStaleSocket(socket); err != nil {
+			return 1, err
+		}
+		dir := conf.UnixSocket.hostDir(uid)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return 1, err
+		}
+		defer os.RemoveAll(dir)
+		target, err := filepath.Rel(filepath.Dir(socket), conf.UnixSocket.hostSocket(uid))
+		if err != nil {
+			return 1, err
+		}
+		if err := os.Symlink(target, socket); err != nil {
+			return 1, err
+		}
+		defer func() {
+			//unless another instance took the path over
+			if t, err := os.Readlink(socket); err == nil && t == target {
+				os.Remove(socket)
+			}
+		}()
+		log.Println("unix socket:", socket)
+	}
+	if conf.Limits.Memory > 0 {
+		log.Println("memory limit:", conf.Limits.Memory, "bytes, redis maxmemory:", conf.Limits.maxmemory(), "bytes")
+	}
+
+	if conf.Network.enabled() {
+		if err := setupNetBridge(&conf.Network); err != nil {
+			return 1, err
+		}
+		log.Println("bridge " + conf.Network.Bridge + " up")
+		ipam := newIPAM(reg)
+		defer ipam.release(uid)
+		if conf.Network.IP == "auto" {
+			if conf.Network.IP, err = ipam.allocate(uid, &conf.Network); err != nil {
+				return 1, err
+			}
+		} else if conf.Network.IP != "" {
+			if err := validateIPAddr(conf.Network.IP, conf.Network.Subnet, conf.Network.Gateway); err != nil {
+				return 1, err
+			}
+			if err := ipam.lease(uid, conf.Network.Bridge, conf.Network.IP); err != nil {
+				return 1, err
+			}
+		}
+		if conf.Network.IPv6 != "" {
+			if err := validateIPAddr(conf.Network.IPv6, conf.Network.IPv6Subnet, conf.Network.IPv6Gateway); err != nil {
+				return 1, err
+			}
+			if err := ipam.lease(uid, conf.Network.Bridge, conf.Network.IPv6); err != nil {
+				return 1, err
+			}
+		}
+		if conf.Network.IP != "" {
+			log.Println("container IP address:", conf.Network.IP)
+		}
+		if conf.Network.IPv6 != "" {
+			log.Println("container IPv6 address:", conf.Network.IPv6)
+		}
+
+		if len(conf.Network.Ports) > 0 {
+			if err := publishPorts(uid, &conf.Network); err != nil {
+				return 1, err
+			}
+			defer unpublishPorts(uid)
+			log.Println("published ports:", strings.Join(conf.Network.Ports, ", "))
+		}
+	}
+
+	if conf.Sentinel != nil {
+		if err := conf.Sentinel.resolve(reg, c.GlobalString("working_dir"), &conf.Network); err != nil {
+			return 1, err
+		}
+		for _, m := range conf.Sentinel.Masters {
+			log.Println("monitoring", m.Name, "at", m.Master)
+		}
+		log.Println("writing sentinel configuration")
+		if err := writeSentinelConf(path.Join(rootfs, "etc"), conf); err != nil {
+			return 1, err
+		}
+	} else {
+		if err := conf.Replication.resolve(reg, c.GlobalString("working_dir"), &conf.Network); err != nil {
+			return 1, err
+		}
+		if master := conf.Replication.ReplicaOf; master != "" {
+			log.Println("replica of", master)
+		}
+
+		if err := validateDirectives(conf.redisDirectives(), rootfs); err != nil {
 			return 1, err
 		}
-		log.Println("bridge " + vethBridge + " up " + vethNetwork)
-		if err := validateIPAddr(ipAddr); err != nil {
+
+		log.Println("writing redis configuration")
+		if err := writeRedisConf(path.Join(rootfs, "etc"), conf); err != nil {
 			return 1, err
 		}
-		log.Println("container IP address:", ipAddr)
-		ipAddr = ipAddr + "/8"
 	}
 
 	factory, err := libcontainer.New(rootfs)
@@ -124,38 +413,273 @@ func start(c *cli.Context) (int, error) {
 		return 1, err
 	}
 
-	container, err := factory.Create(uid, loadConfig(uid, rootfs, ipAddr))
+	config, err := loadConfig(uid, rootfs, conf)
+	if err != nil {
+		return 1, err
+	}
+	if rdb := c.GlobalString("restore"); rdb != "" {
+		dst := hostPath(rootfs, config.Mounts, rdbPath(conf.redisDirectives()))
+		if err := restoreRDB(rdb, dst); err != nil {
+			return 1, err
+		}
+		log.Println("restored", rdb, "to", dst)
+		if strings.ToLower(directiveValue(conf.redisDirectives(), "appendonly")) == "yes" {
+			log.Println("appendonly is enabled, redis-server loads the AOF file instead of the restored RDB file if there is one")
+		}
+	}
+	inst := &instance{
+		UID:       uid,
+		Pid:       os.Getpid(),
+		Rootfs:    rootfs,
+		IP:        conf.Network.IP,
+		IPv6:      conf.Network.IPv6,
+		Socket:    conf.UnixSocket.Path,
+		Port:      redisPort(conf.redisDirectives()),
+		Config:    conf.redisDirectives(),
+		StartedAt: time.Now(),
+		Topology:  topology,
+	}
+	if conf.Network.enabled() {
+		inst.Bridge = conf.Network.Bridge
+	}
+
+	metrics := newMetricsExporter(inst)
+	if addr := c.GlobalString("metrics-addr"); addr != "" {
+		if err := metrics.listen(addr, log); err != nil {
+			return 1, err
+		}
+		log.Println("metrics endpoint: http://" + addr + "/metrics")
+	}
+
+	if interval := c.GlobalDuration("backup-interval"); interval > 0 {
+		backups, err := newBackupScheduler(inst, hostPath(rootfs, config.Mounts, rdbPath(conf.redisDirectives())), c, log)
+		if err != nil {
+			return 1, err
+		}
+		go backups.run()
+		defer backups.shutdown()
+		log.Println("backups every", interval, "in", backups.dir)
+	}
+
+	//the container is re-created on each restart, keeping the rootfs and the data directory
+	sup := newSupervisor(abort)
+	consecutive := 0
+	for {
+		//each run gets a new config, a new veth pair in particular: the host end of the previous one may still exist
+		if inst.Restarts > 0 {
+			if config, err = loadConfig(uid, rootfs, conf); err != nil {
+				return 1, err
+			}
+		}
+		container, err := factory.Create(uid, config)
+		if err != nil {
+			return 1, err
+		}
+		metrics.setContainer(container, inst.Restarts)
+		runStart := time.Now()
+		exit, err := runRedis(c, container, sup, reg, inst, conf, log)
+
+		// destroy the container.
+		log.Println("Cleaning up")
+		container.Destroy()
+		if err != nil {
+			return 1, err
+		}
+
+		if exit == exitUnhealthy {
+			log.Println("redis-server killed after failing its health checks")
+		} else {
+			log.Println("redis-server exited with status", exit)
+		}
+		if sup.stopped() || reg.stopRequested(uid) {
+			return exit, nil
+		}
+		if !policy.shouldRestart(exit, inst.Restarts) {
+			if policy.Name != "no" && exit != 0 {
+				log.Println("giving up after", inst.Restarts, "restart(s)")
+			}
+			return exit, nil
+		}
+
+		//the backoff is reset once redis-server ran long enough
+		if time.Since(runStart) > maxRestartBackoff {
+			consecutive = 0
+		}
+		backoff := restartBackoff(consecutive)
+		consecutive++
+		inst.Restarts++
+		log.Printf("restarting redis-server in %s (restart %d)", backoff, inst.Restarts)
+		select {
+		case <-sup.stopping:
+			return exit, nil
+		case <-time.After(backoff):
+		}
+	}
+}
+
+//runs redis-server in the container until it exits and returns its exit status,
+//exitUnhealthy if it was killed after failing its health checks (--health-kill)
+func runRedis(c *cli.Context, container libcontainer.Container, sup *supervisor, reg *registry, inst *instance, conf *instanceConfig, log *logger) (int, error) {
+	health, err := newHealthChecker(inst, reg, c, log)
 	if err != nil {
 		return 1, err
 	}
 	process := &libcontainer.Process{
-		Args:   []string{"redis-server", "/etc/redis.conf"},
+		Args:   conf.command(),
 		Env:    []string{"PATH=/usr/local/bin"},
 		User:   "root",
 		Stdin:  os.Stdin,
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
+		Stdout: log.processWriter(os.Stdout),
+		Stderr: log.processWriter(os.Stderr),
 	}
 
-	go handleSignals(process)
+	//the socket of a previous run would be chowned instead of the one redis-server creates
+	if conf.UnixSocket.Path != "" {
+		if err := os.Remove(conf.UnixSocket.hostSocket(inst.UID)); err != nil && !os.IsNotExist(err) {
+			return 1, err
+		}
+	}
 
+	sup.setProcess(process)
+	defer sup.setProcess(nil)
 	if err := container.Start(process); err != nil {
 		return 1, err
 	}
+	//stopped while starting
+	if sup.stopped() {
+		process.Signal(syscall.SIGTERM)
+	}
+
+	initPid, err := process.Pid()
+	if err != nil {
+		return 1, err
+	}
+	if conf.UnixSocket.Path != "" && conf.UnixSocket.Owner != "" {
+		go chownSocket(&conf.UnixSocket, conf.UnixSocket.hostSocket(inst.UID), log)
+	}
+	inst.InitPid = initPid
+	if err := reg.save(inst); err != nil {
+		log.Println("failed to register instance:", err)
+	}
+
+	go health.run()
+	exited := make(chan struct{})
+	killed := make(chan struct{})
+	go func() {
+		select {
+		case <-health.unhealthy:
+			close(killed)
+			log.Println("killing redis-server")
+			process.Signal(syscall.SIGKILL)
+		case <-exited:
+		}
+	}()
 
 	// wait for the process to finish.
 	status, err := process.Wait()
+	close(exited)
+	health.shutdown()
 	if err != nil {
 		return 1, err
 	}
 
-	// destroy the container.
-	log.Println("Cleaning up")
-	container.Destroy()
-
+	select {
+	case <-killed:
+		return exitUnhealthy, nil
+	default:
+	}
 	return utils.ExitStatus(status.Sys().(syscall.WaitStatus)), nil
 }
 
+//waits for redis-server to create its unix socket at the host path socket and gives it to the configured owner
+func chownSocket(u *unixSocketConfig, socket string, log *logger) {
+	uid, gid, _ := u.owner()
+	for i := 0; i < 300; i++ {
+		if _, err := os.Lstat(socket); err == nil {
+			if err := os.Lchown(socket, uid, gid); err != nil {
+				log.Println("failed to change unix socket owner:", err)
+			}
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Println("unix socket", u.Path, "not created")
+}
+
+//removes the socket (or socket symlink) left at path by a previous instance. Anything else, or a socket
+//still accepting connections, is left untouched and reported
+func removeStaleSocket(p string) error {
+	fi, err := os.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch mode := fi.Mode(); {
+	case mode&os.ModeSocket != 0:
+	case mode&os.ModeSymlink != 0:
+		if target, err := os.Readlink(p); err != nil || !strings.HasPrefix(target, ".sc-redis-") {
+			return fmt.Errorf("%s exists and is not a unix socket", p)
+		}
+	default:
+		return fmt.Errorf("%s exists and is not a unix socket", p)
+	}
+	if c, err := net.DialTimeout("unix", p, time.Second); err == nil {
+		c.Close()
+		return fmt.Errorf("unix socket %s is in use", p)
+	}
+	return os.Remove(p)
+}
+
+//re-executes sc-redis in the background with the same arguments, the child logs to <state-dir>/<name>.log.
+//Waits for the child to start the instances uids and prints them
+func detach(c *cli.Context, name string, uids []string) (int, error) {
+	reg, err := newRegistry(c.GlobalString("state-dir"))
+	if err != nil {
+		return 1, err
+	}
+
+	logPath := reg.logPath(name)
+	out, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 1, err
+	}
+	defer out.Close()
+
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.Env = append(os.Environ(), uidEnv+"="+name)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 1, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	for i := 0; i < 300*len(uids); i++ {
+		select {
+		case err := <-exited:
+			return 1, fmt.Errorf("sc-redis exited during startup (%v), see %s", err, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+		started := 0
+		for _, uid := range uids {
+			if reg.started(uid) {
+				started++
+			}
+		}
+		if started == len(uids) {
+			fmt.Println(strings.Join(uids, "\n"))
+			return 0, nil
+		}
+	}
+	return 1, fmt.Errorf("timeout waiting for %s to start, see %s", strings.Join(uids, ", "), logPath)
+}
+
 func handleSignals(container *libcontainer.Process) {
 	sigc := make(chan os.Signal, 10)
 	signal.Notify(sigc)
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libcontainer"
+)
+
+//redis INFO fields exported as metrics
+var infoMetrics = []struct {
+	field, name, kind, help string
+}{
+	{"uptime_in_seconds", "redis_uptime_seconds", "gauge", "redis-server uptime"},
+	{"connected_clients", "redis_connected_clients", "gauge", "Number of client connections"},
+	{"blocked_clients", "redis_blocked_clients", "gauge", "Number of clients blocked on a blocking call"},
+	{"used_memory", "redis_memory_used_bytes", "gauge", "Memory allocated by redis"},
+	{"used_memory_rss", "redis_memory_rss_bytes", "gauge", "Memory allocated by redis as seen by the operating system"},
+	{"used_memory_peak", "redis_memory_peak_bytes", "gauge", "Peak memory allocated by redis"},
+	{"mem_fragmentation_ratio", "redis_memory_fragmentation_ratio", "gauge", "Ratio between used_memory_rss and used_memory"},
+	{"loading", "redis_loading", "gauge", "1 if redis is loading its dataset"},
+	{"rdb_changes_since_last_save", "redis_rdb_changes_since_last_save", "gauge", "Changes since the last RDB save"},
+	{"rdb_bgsave_in_progress", "redis_rdb_bgsave_in_progress", "gauge", "1 if a RDB save is in progress"},
+	{"rdb_last_save_time", "redis_rdb_last_save_timestamp_seconds", "gauge", "Time of the last successful RDB save"},
+	{"rdb_last_bgsave_status", "redis_rdb_last_bgsave_ok", "gauge", "1 if the last RDB save succeeded"},
+	{"aof_enabled", "redis_aof_enabled", "gauge", "1 if AOF is enabled"},
+	{"aof_rewrite_in_progress", "redis_aof_rewrite_in_progress", "gauge", "1 if an AOF rewrite is in progress"},
+	{"aof_last_bgrewrite_status", "redis_aof_last_bgrewrite_ok", "gauge", "1 if the last AOF rewrite succeeded"},
+	{"total_connections_received", "redis_connections_received_total", "counter", "Connections accepted by redis"},
+	{"rejected_connections", "redis_rejected_connections_total", "counter", "Connections rejected because of maxclients"},
+	{"total_commands_processed", "redis_commands_processed_total", "counter", "Commands processed by redis"},
+	{"instantaneous_ops_per_sec", "redis_instantaneous_ops_per_sec", "gauge", "Commands processed per second"},
+	{"expired_keys", "redis_expired_keys_total", "counter", "Keys expired"},
+	{"evicted_keys", "redis_evicted_keys_total", "counter", "Keys evicted because of maxmemory"},
+	{"keyspace_hits", "redis_keyspace_hits_total", "counter", "Successful key lookups"},
+	{"keyspace_misses", "redis_keyspace_misses_total", "counter", "Failed key lookups"},
+	{"connected_slaves", "redis_connected_slaves", "gauge", "Number of connected slaves"},
+}
+
+//metricsExporter serves the Prometheus metrics of the instance run by the host sc-redis process
+type metricsExporter struct {
+	instance *instance
+
+	mu        sync.Mutex
+	container libcontainer.Container
+	restarts  int
+}
+
+func newMetricsExporter(i *instance) *metricsExporter {
+	return &metricsExporter{instance: i}
+}
+
+//sets the container of the current redis-server run
+func (m *metricsExporter) setContainer(container libcontainer.Container, restarts int) {
+	m.mu.Lock()
+	m.container = container
+	m.restarts = restarts
+	m.mu.Unlock()
+}
+
+//serves /metrics on addr (host:port) in the background
+func (m *metricsExporter) listen(addr string, log *logger) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to serve metrics on %s: %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Println("metrics endpoint stopped:", err)
+		}
+	}()
+	return nil
+}
+
+func (m *metricsExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	container, restarts := m.container, m.restarts
+	m.mu.Unlock()
+
+	out := newMetricsWriter(`uid="` + m.instance.UID + `"`)
+	out.write("sc_redis_uptime_seconds", "gauge", "Time since the instance started", time.Since(m.instance.StartedAt).Seconds())
+	out.write("sc_redis_restarts_total", "counter", "redis-server restarts by the restart policy", float64(restarts))
+	if container != nil {
+		if stats, err := container.Stats(); err == nil {
+			out.writeContainerStats(stats)
+		}
+	}
+
+	info, err := redisInfo(m.instance)
+	up := 1.0
+	if err != nil {
+		up = 0
+	}
+	out.write("redis_up", "gauge", "1 if redis-server answered INFO", up)
+	if err == nil {
+		out.writeInfo(info)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(out.buf.Bytes())
+}
+
+//returns the fields of the redis INFO reply by name, keyspace lines (e.g: db0:keys=1,expires=0) included
+func redisInfo(i *instance) (map[string]string, error) {
+	client, err := dialInstance(i, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.info()
+}
+
+//writes metrics in the Prometheus text format
+type metricsWriter struct {
+	buf     bytes.Buffer
+	labels  string //labels of every metric
+	written map[string]bool
+}
+
+func newMetricsWriter(labels string) *metricsWriter {
+	return &metricsWriter{labels: labels, written: map[string]bool{}}
+}
+
+//writes a sample, preceded by its HELP and TYPE the first time the metric is written
+func (w *metricsWriter) write(name, kind, help string, value float64, labels ...string) {
+	if !w.written[name] {
+		fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		w.written[name] = true
+	}
+	fmt.Fprintf(&w.buf, "%s{%s} %s\n", name, strings.Join(append([]string{w.labels}, labels...), ","),
+		strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (w *metricsWriter) writeContainerStats(stats *libcontainer.Stats) {
+	if cg := stats.CgroupStats; cg != nil {
+		mem := cg.MemoryStats
+		w.write("sc_redis_container_memory_usage_bytes", "gauge", "Memory usage of the container cgroup", float64(mem.Usage))
+		w.write("sc_redis_container_memory_max_usage_bytes", "gauge", "Maximum memory usage of the container cgroup", float64(mem.MaxUsage))
+		w.write("sc_redis_container_memory_failcnt", "counter", "Times the container cgroup hit its memory limit", float64(mem.Failcnt))
+		if rss, ok := mem.Stats["rss"]; ok {
+			w.write("sc_redis_container_memory_rss_bytes", "gauge", "Anonymous memory of the container cgroup", float64(rss))
+		}
+		cpu := cg.CpuStats.CpuUsage
+		w.write("sc_redis_container_cpu_usage_seconds_total", "counter", "CPU time consumed by the container cgroup",
+			float64(cpu.TotalUsage)/float64(time.Second))
+		w.write("sc_redis_container_cpu_user_seconds_total", "counter", "User CPU time consumed by the container cgroup",
+			float64(cpu.UsageInUsermode)/float64(time.Second))
+		w.write("sc_redis_container_cpu_kernel_seconds_total", "counter", "Kernel CPU time consumed by the container cgroup",
+			float64(cpu.UsageInKernelmode)/float64(time.Second))
+	}
+	//samples of a metric must be grouped
+	for _, iface := range stats.Interfaces {
+		w.write("sc_redis_container_network_receive_bytes_total", "counter", "Bytes received by the container",
+			float64(iface.RxBytes), `interface="`+iface.Name+`"`)
+	}
+	for _, iface := range stats.Interfaces {
+		w.write("sc_redis_container_network_transmit_bytes_total", "counter", "Bytes sent by the container",
+			float64(iface.TxBytes), `interface="`+iface.Name+`"`)
+	}
+}
+
+func (w *metricsWriter) writeInfo(info map[string]string) {
+	if role, ok := info["role"]; ok {
+		w.write("redis_instance_info", "gauge", "redis-server version and role", 1,
+			`version="`+info["redis_version"]+`"`, `role="`+role+`"`)
+	}
+	for _, m := range infoMetrics {
+		value, ok := info[m.field]
+		if !ok {
+			continue
+		}
+		switch value {
+		case "ok":
+			value = "1"
+		case "err":
+			value = "0"
+		}
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			w.write(m.name, m.kind, m.help, v)
+		}
+	}
+
+	//keyspace: db0:keys=1,expires=0,avg_ttl=0
+	var dbs []string
+	for field := range info {
+		if strings.HasPrefix(field, "db") {
+			if _, err := strconv.Atoi(field[2:]); err == nil {
+				dbs = append(dbs, field)
+			}
+		}
+	}
+	sort.Strings(dbs)
+	for _, m := range []struct{ key, name, help string }{
+		{"keys", "redis_db_keys", "Keys of the database"},
+		{"expires", "redis_db_expiring_keys", "Keys with an expiration of the database"},
+	} {
+		for _, db := range dbs {
+			for _, kv := range strings.Split(info[db], ",") {
+				parts := strings.SplitN(kv, "=", 2)
+				if len(parts) != 2 || parts[0] != m.key {
+					continue
+				}
+				if v, err := strconv.ParseFloat(parts[1], 64); err == nil {
+					w.write(m.name, "gauge", m.help, v, `db="`+db+`"`)
+				}
+			}
+		}
+	}
+}
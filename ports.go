@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+//container port published on the host
+type portMapping struct {
+	HostPort      int
+	ContainerPort int
+}
+
+//parses hostport:containerport, or port when both are the same
+func parsePortMapping(spec string) (*portMapping, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("invalid port mapping %s, expecting hostport:containerport", spec)
+	}
+	var ports []int
+	for _, part := range parts {
+		port, err := strconv.Atoi(part)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %s in %s", part, spec)
+		}
+		ports = append(ports, port)
+	}
+	return &portMapping{HostPort: ports[0], ContainerPort: ports[len(ports)-1]}, nil
+}
+
+//comment tagging the iptables rules of an instance: sc-redis:<uid>:<pid of the host sc-redis process>
+func ruleTag(uid string, pid int) string {
+	return fmt.Sprintf("sc-redis:%s:%d", uid, pid)
+}
+
+//installs DNAT and MASQUERADE rules publishing the container ports on the host
+func publishPorts(uid string, n *networkConfig) error {
+	if err := reconcilePortRules(); err != nil {
+		return err
+	}
+	tag := []string{"-m", "comment", "--comment", ruleTag(uid, os.Getpid())}
+	rules := [][]string{
+		{"-t", "nat", "-A", "POSTROUTING", "-s", n.IP, "!", "-o", n.Bridge, "-j", "MASQUERADE"},
+	}
+	for _, spec := range n.Ports {
+		m, err := parsePortMapping(spec)
+		if err != nil {
+			return err
+		}
+		host, container := strconv.Itoa(m.HostPort), strconv.Itoa(m.ContainerPort)
+		dest := n.IP + ":" + container
+		rules = append(rules,
+			[]string{"-t", "nat", "-A", "PREROUTING", "-p", "tcp", "-m", "addrtype", "--dst-type", "LOCAL", "--dport", host, "-j", "DNAT", "--to-destination", dest},
+			[]string{"-t", "nat", "-A", "OUTPUT", "-p", "tcp", "!", "-d", "127.0.0.0/8", "-m", "addrtype", "--dst-type", "LOCAL", "--dport", host, "-j", "DNAT", "--to-destination", dest},
+			//hairpin, when the container reaches itself through the host port
+			[]string{"-t", "nat", "-A", "POSTROUTING", "-p", "tcp", "-s", n.IP, "-d", n.IP, "--dport", container, "-j", "MASQUERADE"},
+			[]string{"-t", "filter", "-I", "FORWARD", "-p", "tcp", "-d", n.IP, "-o", n.Bridge, "--dport", container, "-j", "ACCEPT"},
+		)
+	}
+	for _, rule := range rules {
+		if _, err := iptables(append(rule, tag...)...); err != nil {
+			unpublishPorts(uid)
+			return err
+		}
+	}
+	return nil
+}
+
+//removes the rules of the given instance
+func unpublishPorts(uid string) error {
+	return removePortRules(func(owner string, pid int) bool {
+		return owner == uid
+	})
+}
+
+//removes the rules left by instances whose host sc-redis process is gone (e.g: crashed or killed)
+func reconcilePortRules() error {
+	return removePortRules(func(owner string, pid int) bool {
+		return !processAlive(pid)
+	})
+}
+
+func removePortRules(match func(uid string, pid int) bool) error {
+	for _, table := range []string{"nat", "filter"} {
+		out, err := iptables("-t", table, "-S")
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(strings.Replace(line, `"`, "", -1))
+			if len(fields) < 2 || fields[0] != "-A" {
+				continue
+			}
+			for i, f := range fields {
+				if f != "--comment" || i+1 >= len(fields) || !strings.HasPrefix(fields[i+1], "sc-redis:") {
+					continue
+				}
+				tag := strings.Split(fields[i+1], ":")
+				if len(tag) != 3 {
+					break
+				}
+				pid, _ := strconv.Atoi(tag[2])
+				if match(tag[1], pid) {
+					fields[0] = "-D"
+					if _, err := iptables(append([]string{"-t", table}, fields...)...); err != nil {
+						return err
+					}
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func iptables(args ...string) (string, error) {
+	out, err := exec.Command("iptables", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("iptables %s: %v (%s)", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
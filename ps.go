@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/docker/pkg/units"
+)
+
+type psEntry struct {
+	UID         string    `json:"uid"`
+	Pid         int       `json:"pid"`
+	InitPid     int       `json:"init_pid"`
+	IP          string    `json:"ip"`
+	IPv6        string    `json:"ipv6"`
+	Port        int       `json:"port"`
+	Socket      string    `json:"unix_socket,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	Uptime      int64     `json:"uptime"` //seconds
+	MemoryUsage int64     `json:"memory_usage"`
+	Restarts    int       `json:"restarts"`
+}
+
+func psAction(c *cli.Context) {
+	format := c.String("format")
+	if format != "table" && format != "json" {
+		log.Fatalf("unknown format %s, expecting table or json", format)
+	}
+
+	reg, err := newRegistry(c.GlobalString("state-dir"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	instances, err := findInstances(reg, c.GlobalString("working_dir"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	entries := []*psEntry{}
+	for _, ri := range instances {
+		e := &psEntry{
+			UID:       ri.UID,
+			Pid:       ri.Pid,
+			InitPid:   ri.InitPid,
+			IP:        ri.IP,
+			IPv6:      ri.IPv6,
+			Port:      ri.Port,
+			Socket:    ri.Socket,
+			StartedAt: ri.StartedAt,
+			Uptime:    int64(time.Since(ri.StartedAt).Seconds()),
+			Restarts:  ri.Restarts,
+		}
+		e.MemoryUsage, _ = memoryUsage(ri.UID)
+		entries = append(entries, e)
+	}
+
+	if format == "json" {
+		if err := json.NewEncoder(os.Stdout).Encode(entries); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "UID\tPID\tIP\tPORT\tUPTIME\tMEMORY")
+	for _, e := range entries {
+		ip := strings.Trim(e.IP+","+e.IPv6, ",")
+		if ip == "" {
+			ip = "host"
+		}
+		port := fmt.Sprint(e.Port)
+		if e.Socket != "" {
+			port = "unix:" + e.Socket
+		}
+		pid := "-"
+		if e.Pid > 0 {
+			pid = fmt.Sprint(e.Pid)
+		}
+		uptime := units.HumanDuration(time.Duration(e.Uptime) * time.Second)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.UID, pid, ip, port, uptime, units.HumanSize(e.MemoryUsage))
+	}
+	w.Flush()
+}
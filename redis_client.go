@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//minimal RESP client used by the host to talk to the redis-server of an instance
+
+//error reply sent by redis
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+type redisClient struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+func dialRedis(network, addr string, timeout time.Duration) (*redisClient, error) {
+	conn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &redisClient{conn: conn, r: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+//connects to the redis-server of the instance and authenticates if needed
+func dialInstance(i *instance, timeout time.Duration) (*redisClient, error) {
+	network, addr := i.redisNetAddr()
+	client, err := dialRedis(network, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if password := directiveValue(i.Config, "requirepass"); password != "" {
+		if _, err := client.do("AUTH", password); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (c *redisClient) Close() error {
+	return c.conn.Close()
+}
+
+//sends a command and reads its reply. Replies are string (status and bulk), int64, []interface{},
+//nil (null bulk or multi bulk) or a redisError
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, cmd); err != nil {
+		return nil, err
+	}
+	reply, err := c.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+//sends INFO and returns its fields by name, keyspace lines (e.g: db0:keys=1,expires=0) included
+func (c *redisClient) info(section ...string) (map[string]string, error) {
+	reply, err := c.do(append([]string{"INFO"}, section...)...)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected INFO reply %v", reply)
+	}
+	info := map[string]string{}
+	for _, line := range strings.Split(s, "\r\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
+			info[parts[0]] = parts[1]
+		}
+	}
+	return info, nil
+}
+
+func (c *redisClient) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, fmt.Errorf("invalid redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		replies := make([]interface{}, size)
+		for i := range replies {
+			if replies[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("invalid redis reply %q", line)
+}
+
+//returns true if the error means redis closed the connection
+func isConnClosed(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if e, ok := err.(*net.OpError); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*os.SyscallError); ok {
+		err = e.Err
+	}
+	return err == syscall.ECONNRESET || err == syscall.EPIPE
+}
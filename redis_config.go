@@ -1,10 +1,10 @@
 package main
 
 import (
-	"os"
+	"io/ioutil"
 	"path"
+	"strconv"
 	"strings"
-	"text/template"
 )
 
 //default redis configuration (https://raw.githubusercontent.com/antirez/redis/2.8/redis.conf)
@@ -837,28 +837,176 @@ hz 10
 # in order to commit the file to the disk more incrementally and avoid
 # big latency spikes.
 aof-rewrite-incremental-fsync yes
-
-{{ range $i, $value := . }}
-  {{ $value }}
-{{ end }}
 `
 
-func writeRawRedisConf(basePath string, rawConf string) error {
-	return writeRedisConf(basePath, strings.Split(rawConf, ","))
+//splits a comma separated configuration, e.g: "requirepass foobar, port 9999".
+//Commas inside a directive must be escaped, e.g: "requirepass foo\,bar, port 9999"
+func splitRawConf(rawConf string) []string {
+	var (
+		conf []string
+		line string
+	)
+	flush := func() {
+		if line = strings.TrimSpace(line); line != "" {
+			conf = append(conf, line)
+		}
+		line = ""
+	}
+	for i := 0; i < len(rawConf); i++ {
+		switch {
+		case rawConf[i] == '\\' && i+1 < len(rawConf) && rawConf[i+1] == ',':
+			line += ","
+			i++
+		case rawConf[i] == ',':
+			flush()
+		default:
+			line += string(rawConf[i])
+		}
+	}
+	flush()
+	return conf
+}
+
+//configuration lines of a redis.conf file, without comments and blank lines
+func splitConf(data string) []string {
+	var conf []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			conf = append(conf, line)
+		}
+	}
+	return conf
+}
+
+//unquoted value of the given directive in the configuration lines, the last one wins
+func directiveValue(conf []string, name string) string {
+	value := ""
+	for _, line := range conf {
+		if n, args := parseDirective(line); n == name && len(args) > 0 {
+			for i := range args {
+				args[i] = unquoteArg(args[i])
+			}
+			value = strings.Join(args, " ")
+		}
+	}
+	return value
+}
+
+//path of the RDB file inside the container given the redis configuration lines, redis-server runs in /
+func rdbPath(conf []string) string {
+	dir := directiveValue(conf, "dir")
+	file := directiveValue(conf, "dbfilename")
+	if file == "" {
+		file = "dump.rdb"
+	}
+	return path.Join("/", dir, file)
+}
+
+//port redis listens on given its configuration lines
+func redisPort(conf []string) int {
+	if p, err := strconv.Atoi(directiveValue(conf, "port")); err == nil {
+		return p
+	}
+	return 6379
+}
+
+//returns true if one of the configuration lines sets the given directive
+func hasDirective(conf []string, name string) bool {
+	for _, line := range conf {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.ToLower(fields[0]) == name {
+			return true
+		}
+	}
+	return false
+}
+
+//directives that may be set several times, user lines replace all the default ones
+var multiValuedDirectives = map[string]bool{
+	"save": true,
+}
+
+//directives set once per value of their first argument
+var keyedDirectives = map[string]bool{
+	"rename-command":             true,
+	"client-output-buffer-limit": true,
+}
+
+//key identifying what a directive line sets, e.g: "port" or "client-output-buffer-limit slave"
+func directiveKey(line string) string {
+	name, args := parseDirective(line)
+	if keyedDirectives[name] && len(args) > 0 {
+		return name + " " + strings.ToLower(args[0])
+	}
+	return name
+}
+
+//redisConfFile is the default redisConf in which directives are replaced by name
+type redisConfFile struct {
+	overrides map[string][]string //directive lines by key
+	keys      []string            //keys in the order they were first set
 }
 
-func writeRedisConf(basePath string, conf []string) error {
-	//write the container.json
-	f, err := os.Create(path.Join(basePath, "redis.conf"))
-	if err != nil {
-		return err
+func newRedisConfFile(directives []string) *redisConfFile {
+	f := &redisConfFile{overrides: map[string][]string{}}
+	for _, line := range directives {
+		f.set(line)
 	}
-	defer f.Close()
+	return f
+}
+
+func (f *redisConfFile) set(line string) {
+	key := directiveKey(line)
+	if _, ok := f.overrides[key]; !ok {
+		f.keys = append(f.keys, key)
+	}
+	if name, _ := parseDirective(line); multiValuedDirectives[name] {
+		f.overrides[key] = append(f.overrides[key], line)
+	} else {
+		f.overrides[key] = []string{line}
+	}
+}
 
-	t := template.New("redis.conf")
-	t, err = t.Parse(redisConf)
-	if err != nil {
-		return err
+//default configuration with overridden directives written in place, new directives are written at the end
+func (f *redisConfFile) String() string {
+	var (
+		lines   []string
+		written = map[string]bool{}
+	)
+	for _, line := range strings.Split(redisConf, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			lines = append(lines, line)
+			continue
+		}
+		key := directiveKey(trimmed)
+		override, ok := f.overrides[key]
+		if !ok {
+			lines = append(lines, line)
+			continue
+		}
+		if !written[key] {
+			lines = append(lines, override...)
+			written[key] = true
+		}
 	}
-	return t.Execute(f, conf)
+
+	header := false
+	for _, key := range f.keys {
+		if written[key] {
+			continue
+		}
+		if !header {
+			lines = append(lines, "################################## SC-REDIS ###################################", "")
+			header = true
+		}
+		lines = append(lines, f.overrides[key]...)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func writeRedisConf(basePath string, conf *instanceConfig) error {
+	f := newRedisConfFile(conf.redisDirectives())
+	return ioutil.WriteFile(path.Join(basePath, "redis.conf"), []byte(f.String()), 0644)
 }
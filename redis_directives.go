@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type argKind int
+
+const (
+	stringArg argKind = iota
+	intArg
+	sizeArg //e.g: 1gb, 64m, 1024
+	boolArg //yes or no
+	enumArg
+)
+
+type argSpec struct {
+	kind argKind
+	enum []string //allowed values of an enumArg
+}
+
+type directiveSpec struct {
+	args     []argSpec
+	variadic bool //the last argument may be repeated
+}
+
+var (
+	intRegexp  = regexp.MustCompile(`^-?[0-9]+$`)
+	sizeRegexp = regexp.MustCompile(`(?i)^[0-9]+([kmg]b?)?$`)
+)
+
+func enumOf(values ...string) argSpec {
+	return argSpec{kind: enumArg, enum: values}
+}
+
+//directives commented out in redisConf and directives needing more than the type inferred from their default value
+var directiveSpecs = map[string]directiveSpec{
+	"include":                {args: []argSpec{{kind: stringArg}}},
+	"bind":                   {args: []argSpec{{kind: stringArg}}, variadic: true},
+	"unixsocket":             {args: []argSpec{{kind: stringArg}}},
+	"unixsocketperm":         {args: []argSpec{{kind: intArg}}},
+	"loglevel":               {args: []argSpec{enumOf("debug", "verbose", "notice", "warning")}},
+	"syslog-enabled":         {args: []argSpec{{kind: boolArg}}},
+	"syslog-ident":           {args: []argSpec{{kind: stringArg}}},
+	"syslog-facility":        {args: []argSpec{enumOf("user", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7")}},
+	"slaveof":                {args: []argSpec{{kind: stringArg}, {kind: intArg}}},
+	"masterauth":             {args: []argSpec{{kind: stringArg}}},
+	"repl-ping-slave-period": {args: []argSpec{{kind: intArg}}},
+	"repl-timeout":           {args: []argSpec{{kind: intArg}}},
+	"repl-backlog-size":      {args: []argSpec{{kind: sizeArg}}},
+	"repl-backlog-ttl":       {args: []argSpec{{kind: intArg}}},
+	"min-slaves-to-write":    {args: []argSpec{{kind: intArg}}},
+	"min-slaves-max-lag":     {args: []argSpec{{kind: intArg}}},
+	"requirepass":            {args: []argSpec{{kind: stringArg}}},
+	"rename-command":         {args: []argSpec{{kind: stringArg}, {kind: stringArg}}},
+	"maxclients":             {args: []argSpec{{kind: intArg}}},
+	"maxmemory":              {args: []argSpec{{kind: sizeArg}}},
+	"maxmemory-policy":       {args: []argSpec{enumOf("volatile-lru", "allkeys-lru", "volatile-random", "allkeys-random", "volatile-ttl", "noeviction")}},
+	"maxmemory-samples":      {args: []argSpec{{kind: intArg}}},
+	"appendfsync":            {args: []argSpec{enumOf("always", "everysec", "no")}},
+	"client-output-buffer-limit": {
+		args: []argSpec{enumOf("normal", "slave", "pubsub"), {kind: sizeArg}, {kind: sizeArg}, {kind: intArg}},
+	},
+}
+
+//directives of the default redisConf with their specs, built on first use
+var knownDirectives map[string]directiveSpec
+
+//splits a configuration line in a directive name and its arguments, double quoted arguments may contain spaces
+func parseDirective(line string) (string, []string) {
+	var (
+		fields  []string
+		current string
+		quoted  bool
+		inField bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(line):
+			//escaped character of a double quoted argument, e.g: "foo\"bar"
+			current += line[i : i+2]
+			i++
+		case c == '"':
+			quoted = !quoted
+			inField = true
+			current += string(c)
+		case (c == ' ' || c == '\t') && !quoted:
+			if inField {
+				fields = append(fields, current)
+			}
+			current, inField = "", false
+		default:
+			current += string(c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, current)
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return strings.ToLower(fields[0]), fields[1:]
+}
+
+//removes the double quotes of a directive argument and unescapes it, as redis-server does
+func unquoteArg(arg string) string {
+	if len(arg) < 2 || arg[0] != '"' || arg[len(arg)-1] != '"' {
+		return arg
+	}
+	arg = arg[1 : len(arg)-1]
+	var value []byte
+	for i := 0; i < len(arg); i++ {
+		if arg[i] != '\\' || i+1 == len(arg) {
+			value = append(value, arg[i])
+			continue
+		}
+		i++
+		switch arg[i] {
+		case 'n':
+			value = append(value, '\n')
+		case 'r':
+			value = append(value, '\r')
+		case 't':
+			value = append(value, '\t')
+		case 'b':
+			value = append(value, '\b')
+		case 'a':
+			value = append(value, '\a')
+		case 'x':
+			if i+2 < len(arg) {
+				if b, err := strconv.ParseUint(arg[i+1:i+3], 16, 8); err == nil {
+					value = append(value, byte(b))
+					i += 2
+					continue
+				}
+			}
+			value = append(value, arg[i])
+		default:
+			value = append(value, arg[i])
+		}
+	}
+	return string(value)
+}
+
+//double quotes a directive argument containing spaces, quotes or backslashes
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+//infers the spec of the directives set in the default redisConf from their default value,
+//specs in directiveSpecs take precedence
+func loadKnownDirectives() map[string]directiveSpec {
+	known := map[string]directiveSpec{}
+	for _, line := range splitConf(redisConf) {
+		name, args := parseDirective(line)
+		spec := directiveSpec{}
+		for _, arg := range args {
+			spec.args = append(spec.args, argSpec{kind: inferArgKind(arg)})
+		}
+		known[name] = spec
+	}
+	for name, spec := range directiveSpecs {
+		known[name] = spec
+	}
+	return known
+}
+
+func inferArgKind(value string) argKind {
+	switch {
+	case value == "yes" || value == "no":
+		return boolArg
+	case intRegexp.MatchString(value):
+		return intArg
+	case sizeRegexp.MatchString(value):
+		return sizeArg
+	}
+	return stringArg
+}
+
+//checks the directives against the redis 2.8 directive set before they are written in the container rootfs
+func validateDirectives(conf []string, rootfs string) error {
+	if knownDirectives == nil {
+		knownDirectives = loadKnownDirectives()
+	}
+	for _, line := range conf {
+		if err := validateDirective(line, rootfs); err != nil {
+			return fmt.Errorf("invalid redis directive %q: %v", line, err)
+		}
+	}
+	return nil
+}
+
+func validateDirective(line, rootfs string) error {
+	name, args := parseDirective(line)
+	spec, ok := knownDirectives[name]
+	if !ok {
+		return fmt.Errorf("unknown directive %s", name)
+	}
+
+	//forbidden directives and values
+	switch name {
+	case "daemonize":
+		if len(args) == 1 && strings.ToLower(args[0]) == "yes" {
+			return fmt.Errorf("redis-server must not daemonize inside its container")
+		}
+	case "include":
+		return fmt.Errorf("included files are not available inside the container")
+	case "dir":
+		if len(args) == 1 {
+			dir := path.Join("/", strings.Trim(args[0], `"`))
+			if fi, err := os.Stat(path.Join(rootfs, dir)); err != nil || !fi.IsDir() {
+				return fmt.Errorf("%s is not a directory of the container, use --data-dir to mount a host directory", dir)
+			}
+		}
+	case "save":
+		//save "" disables RDB snapshots
+		if len(args) == 1 && args[0] == `""` {
+			return nil
+		}
+	}
+
+	if len(args) < len(spec.args) || (len(args) > len(spec.args) && !spec.variadic) {
+		return fmt.Errorf("expecting %d argument(s), got %d", len(spec.args), len(args))
+	}
+	for i, arg := range args {
+		s := spec.args[len(spec.args)-1]
+		if i < len(spec.args) {
+			s = spec.args[i]
+		}
+		if err := validateArg(arg, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateArg(value string, spec argSpec) error {
+	switch spec.kind {
+	case intArg:
+		if !intRegexp.MatchString(value) {
+			return fmt.Errorf("%s is not an integer", value)
+		}
+	case sizeArg:
+		if !sizeRegexp.MatchString(value) {
+			return fmt.Errorf("%s is not a size (e.g: 1024, 100mb, 1gb)", value)
+		}
+	case boolArg:
+		if v := strings.ToLower(value); v != "yes" && v != "no" {
+			return fmt.Errorf("expecting yes or no, got %s", value)
+		}
+	case enumArg:
+		for _, v := range spec.enum {
+			if strings.ToLower(value) == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("expecting one of %s, got %s", strings.Join(spec.enum, ", "), value)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//instance is the record of a running sc-redis container kept in the state directory
+type instance struct {
+	UID       string        `json:"uid"`
+	Pid       int           `json:"pid"`      //host sc-redis process
+	InitPid   int           `json:"init_pid"` //redis-server process, as seen from the host
+	Rootfs    string        `json:"rootfs"`
+	IP        string        `json:"ip,omitempty"`
+	IPv6      string        `json:"ipv6,omitempty"`
+	Bridge    string        `json:"bridge,omitempty"`      //bridge of IP and IPv6
+	Socket    string        `json:"unix_socket,omitempty"` //host path of the redis unix socket
+	Port      int           `json:"port"`
+	Config    []string      `json:"config"`
+	StartedAt time.Time     `json:"started_at"`
+	Restarts  int           `json:"restarts"`           //redis-server restarts by the restart policy
+	Health    *healthStatus `json:"health,omitempty"`   //updated by the health checker of the host sc-redis process
+	Topology  string        `json:"topology,omitempty"` //set when launched by up, sharing the host sc-redis process
+}
+
+//returns true if the host sc-redis process of the instance is still running
+func (i *instance) alive() bool {
+	return processAlive(i.Pid)
+}
+
+//network and address redis-server listens on, as reachable from the host
+func (i *instance) redisNetAddr() (string, string) {
+	if i.Socket != "" {
+		return "unix", i.Socket
+	}
+	return "tcp", i.redisAddr()
+}
+
+//TCP address redis-server listens on, as reachable from the host
+func (i *instance) redisAddr() string {
+	host := "127.0.0.1"
+	if i.IP != "" {
+		host = i.IP
+	} else if i.IPv6 != "" {
+		host = i.IPv6
+	}
+	return net.JoinHostPort(host, strconv.Itoa(i.Port))
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	return syscall.Kill(pid, 0) == nil
+}
+
+//registry stores one json file per instance in dir
+type registry struct {
+	dir string
+}
+
+func newRegistry(dir string) (*registry, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &registry{dir: dir}, nil
+}
+
+func (r *registry) path(uid string) string {
+	return path.Join(r.dir, uid+".json")
+}
+
+//path of the output file of a detached instance
+func (r *registry) logPath(uid string) string {
+	return path.Join(r.dir, uid+".log")
+}
+
+//path of the file telling the host sc-redis process its instance is being stopped and must not be restarted
+func (r *registry) stopPath(uid string) string {
+	return path.Join(r.dir, uid+".stop")
+}
+
+func (r *registry) requestStop(uid string) error {
+	return ioutil.WriteFile(r.stopPath(uid), nil, 0600)
+}
+
+func (r *registry) stopRequested(uid string) bool {
+	_, err := os.Stat(r.stopPath(uid))
+	return err == nil
+}
+
+func (r *registry) save(i *instance) error {
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return err
+	}
+	//write then rename so readers never see a partial file
+	tmp := r.path(i.UID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path(i.UID))
+}
+
+//reserves uid for the calling host sc-redis process by registering it before its container exists, so two
+//instances never share a uid. The record of a dead host sc-redis process is replaced
+func (r *registry) reserve(uid string) error {
+	//serializes reservations, a stale record must not be replaced twice
+	dir, err := os.Open(r.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(dir.Fd()), syscall.LOCK_UN)
+
+	if i, err := r.load(uid); err == nil && i.alive() {
+		return fmt.Errorf("name %s is already in use by a running instance (host pid %d)", uid, i.Pid)
+	}
+	r.remove(uid)
+	return r.save(&instance{UID: uid, Pid: os.Getpid(), StartedAt: time.Now()})
+}
+
+//returns true once the instance uid is registered with its redis-server process
+func (r *registry) started(uid string) bool {
+	i, err := r.load(uid)
+	return err == nil && i.InitPid != 0
+}
+
+func (r *registry) load(uid string) (*instance, error) {
+	data, err := ioutil.ReadFile(r.path(uid))
+	if err != nil {
+		return nil, err
+	}
+	i := &instance{}
+	if err := json.Unmarshal(data, i); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (r *registry) remove(uid string) error {
+	os.Remove(r.stopPath(uid))
+	if err := os.Remove(r.path(uid)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *registry) list() ([]*instance, error) {
+	files, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	var instances []*instance
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		i, err := r.load(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		//not an instance, e.g: the leases file of older versions
+		if i.UID == "" {
+			continue
+		}
+		instances = append(instances, i)
+	}
+	return instances, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//when ReplicaOf is set, redis-server is started as a replica (slaveof) of the given master
+type replicationConfig struct {
+	ReplicaOf  string `json:"replica_of" yaml:"replica_of"`   //uid, ip or ip:port of a running instance, or host:port
+	MasterAuth string `json:"master_auth" yaml:"master_auth"` //defaults to the requirepass of a running master instance
+}
+
+//resolves the master to a host:port reachable from the replica container. Running instances are looked up
+//in the registry, their address and requirepass are used. Other masters are expected as host[:port]
+func (r *replicationConfig) resolve(reg *registry, workingDir string, n *networkConfig) error {
+	if r.ReplicaOf == "" {
+		return nil
+	}
+	master, err := findInstance(reg, workingDir, r.ReplicaOf)
+	if err != nil {
+		host, port, splitErr := net.SplitHostPort(r.ReplicaOf)
+		if splitErr != nil {
+			host, port = r.ReplicaOf, "6379"
+			//a bare name is an instance uid or name (e.g: misspelled or not running) unless it resolves as a host
+			if net.ParseIP(host) == nil && nameRegexp.MatchString(host) {
+				if _, lookupErr := net.LookupHost(host); lookupErr != nil || strings.Contains(host, "_") {
+					return err
+				}
+			}
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 || host == "" {
+			return fmt.Errorf("invalid master %s, expecting a running instance or host:port", r.ReplicaOf)
+		}
+		r.ReplicaOf = net.JoinHostPort(host, port)
+		return nil
+	}
+
+	if master.Socket != "" {
+		return fmt.Errorf("master %s only listens on a unix socket, replicas need TCP", master.UID)
+	}
+	var host string
+	switch {
+	case master.IP != "":
+		host = master.IP
+	case master.IPv6 != "":
+		host = master.IPv6
+	case n.enabled():
+		//master on the host network, reached through the bridge gateway of the replica address family
+		if ip := net.ParseIP(n.IP); ip != nil && ip.To4() != nil {
+			host = n.Gateway
+		} else if n.IPv6 != "" {
+			host = n.IPv6Gateway
+		} else {
+			host = n.Gateway
+		}
+	default:
+		host = "127.0.0.1"
+	}
+	r.ReplicaOf = net.JoinHostPort(host, strconv.Itoa(master.Port))
+	if r.MasterAuth == "" {
+		r.MasterAuth = directiveValue(master.Config, "requirepass")
+	}
+	return nil
+}
+
+//slaveof and masterauth directives of a resolved replication configuration
+func (r *replicationConfig) directives() []string {
+	host, port, err := net.SplitHostPort(r.ReplicaOf)
+	if err != nil {
+		return nil
+	}
+	directives := []string{"slaveof " + host + " " + port}
+	if r.MasterAuth != "" {
+		directives = append(directives, "masterauth "+quoteArg(r.MasterAuth))
+	}
+	return directives
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/libcontainer"
+)
+
+const (
+	minRestartBackoff = 1 * time.Second
+	maxRestartBackoff = 1 * time.Minute
+)
+
+//restart policy of redis-server: no, on-failure[:max restarts] or always
+type restartPolicy struct {
+	Name       string
+	MaxRetries int //0 for unlimited restarts
+}
+
+func parseRestartPolicy(spec string) (*restartPolicy, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	p := &restartPolicy{Name: parts[0]}
+	switch p.Name {
+	case "", "no":
+		p.Name = "no"
+	case "always":
+	case "on-failure":
+		if len(parts) == 2 {
+			max, err := strconv.Atoi(parts[1])
+			if err != nil || max < 1 {
+				return nil, fmt.Errorf("invalid restart policy %s, maximum restarts must be a positive integer", spec)
+			}
+			p.MaxRetries = max
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("invalid restart policy %s, expecting no, on-failure[:max restarts] or always", spec)
+	}
+	if len(parts) == 2 {
+		return nil, fmt.Errorf("invalid restart policy %s, only on-failure takes a maximum restarts", spec)
+	}
+	return p, nil
+}
+
+//returns true if redis-server must be restarted after exiting with status, restarts being the number of restarts so far
+func (p *restartPolicy) shouldRestart(status, restarts int) bool {
+	switch p.Name {
+	case "always":
+	case "on-failure":
+		if status == 0 {
+			return false
+		}
+	default:
+		return false
+	}
+	return p.MaxRetries == 0 || restarts < p.MaxRetries
+}
+
+//exponential backoff before the given consecutive restart (starting at 0)
+func restartBackoff(consecutive int) time.Duration {
+	backoff := minRestartBackoff
+	for i := 0; i < consecutive && backoff < maxRestartBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRestartBackoff {
+		backoff = maxRestartBackoff
+	}
+	return backoff
+}
+
+//supervisor forwards the signals received by the host sc-redis process to the current redis-server process.
+//A termination signal means redis-server must not be restarted
+type supervisor struct {
+	mu       sync.Mutex
+	process  *libcontainer.Process
+	stopping chan struct{}
+}
+
+//abort, when closed, stops redis-server like a termination signal (e.g: a topology failing to start)
+func newSupervisor(abort <-chan struct{}) *supervisor {
+	s := &supervisor{stopping: make(chan struct{})}
+	go s.handleSignals()
+	if abort != nil {
+		go func() {
+			<-abort
+			s.signal(syscall.SIGTERM)
+		}()
+	}
+	return s
+}
+
+func (s *supervisor) setProcess(p *libcontainer.Process) {
+	s.mu.Lock()
+	s.process = p
+	s.mu.Unlock()
+}
+
+func (s *supervisor) handleSignals() {
+	sigc := make(chan os.Signal, 10)
+	signal.Notify(sigc)
+	for sig := range sigc {
+		s.signal(sig)
+	}
+}
+
+//forwards sig to redis-server, a termination signal also stops the supervision
+func (s *supervisor) signal(sig os.Signal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch sig {
+	case syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT:
+		if !s.stopped() {
+			close(s.stopping)
+		}
+	}
+	if s.process != nil {
+		s.process.Signal(sig)
+	}
+}
+
+//returns true once the host sc-redis process received a termination signal
+func (s *supervisor) stopped() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
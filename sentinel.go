@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+const (
+	defaultSentinelPort = 26379
+
+	//defaults of the monitored masters, for the sentinel flags and the config file
+	defaultQuorum          = 2
+	defaultDownAfter       = 30 * time.Second
+	defaultFailoverTimeout = 3 * time.Minute
+	defaultParallelSyncs   = 1
+)
+
+//when set, the instance runs redis-server in sentinel mode monitoring Masters
+type sentinelConfig struct {
+	Masters []*sentinelMaster `json:"masters" yaml:"masters"`
+}
+
+type sentinelMaster struct {
+	Name            string   `json:"name" yaml:"name"`
+	Master          string   `json:"master" yaml:"master"` //uid, ip or ip:port of a running instance, or ip:port
+	Quorum          int      `json:"quorum" yaml:"quorum"`
+	AuthPass        string   `json:"auth_pass" yaml:"auth_pass"` //defaults to the requirepass of a running master instance
+	DownAfter       duration `json:"down_after" yaml:"down_after"`
+	FailoverTimeout duration `json:"failover_timeout" yaml:"failover_timeout"`
+	ParallelSyncs   int      `json:"parallel_syncs" yaml:"parallel_syncs"`
+}
+
+//sentinel monitoring the masters given with --monitor name=master
+func newSentinelConfig(c *cli.Context) (*sentinelConfig, error) {
+	s := &sentinelConfig{}
+	for _, monitor := range c.StringSlice("monitor") {
+		parts := strings.SplitN(monitor, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid monitored master %s, expecting name=uid|ip:port", monitor)
+		}
+		s.Masters = append(s.Masters, &sentinelMaster{
+			Name:            parts[0],
+			Master:          parts[1],
+			Quorum:          c.Int("quorum"),
+			DownAfter:       duration(c.Duration("down-after")),
+			FailoverTimeout: duration(c.Duration("failover-timeout")),
+			ParallelSyncs:   c.Int("parallel-syncs"),
+		})
+	}
+	return s, nil
+}
+
+//fills the defaults and checks the monitored masters
+func (s *sentinelConfig) validate() error {
+	if len(s.Masters) == 0 {
+		return fmt.Errorf("sentinel requires at least one monitored master (--monitor name=uid|ip:port)")
+	}
+	names := map[string]bool{}
+	for _, m := range s.Masters {
+		if m.Name == "" || strings.ContainsAny(m.Name, " \t\"") {
+			return fmt.Errorf("invalid master name %q", m.Name)
+		}
+		if names[m.Name] {
+			return fmt.Errorf("master %s monitored twice", m.Name)
+		}
+		names[m.Name] = true
+		if m.Master == "" {
+			return fmt.Errorf("no address for master %s", m.Name)
+		}
+		if m.Quorum == 0 {
+			m.Quorum = defaultQuorum
+		}
+		if m.DownAfter == 0 {
+			m.DownAfter = duration(defaultDownAfter)
+		}
+		if m.FailoverTimeout == 0 {
+			m.FailoverTimeout = duration(defaultFailoverTimeout)
+		}
+		if m.ParallelSyncs == 0 {
+			m.ParallelSyncs = defaultParallelSyncs
+		}
+		if m.Quorum < 0 || m.ParallelSyncs < 0 || m.DownAfter < 0 || m.FailoverTimeout < 0 {
+			return fmt.Errorf("invalid settings for master %s", m.Name)
+		}
+	}
+	return nil
+}
+
+//resolves the masters to ip:port like replicas do, sentinel needs ip addresses
+func (s *sentinelConfig) resolve(reg *registry, workingDir string, n *networkConfig) error {
+	for _, m := range s.Masters {
+		r := &replicationConfig{ReplicaOf: m.Master, MasterAuth: m.AuthPass}
+		if err := r.resolve(reg, workingDir, n); err != nil {
+			return err
+		}
+		host, port, _ := net.SplitHostPort(r.ReplicaOf)
+		if net.ParseIP(host) == nil {
+			addrs, err := net.LookupHost(host)
+			if err != nil || len(addrs) == 0 {
+				return fmt.Errorf("unable to resolve master %s: %v", m.Name, err)
+			}
+			host = addrs[0]
+		}
+		m.Master, m.AuthPass = net.JoinHostPort(host, port), r.MasterAuth
+	}
+	return nil
+}
+
+//sentinel directives of the resolved masters
+func (s *sentinelConfig) directives() []string {
+	var directives []string
+	for _, m := range s.Masters {
+		host, port, _ := net.SplitHostPort(m.Master)
+		directives = append(directives,
+			fmt.Sprintf("sentinel monitor %s %s %s %d", m.Name, host, port, m.Quorum),
+			fmt.Sprintf("sentinel down-after-milliseconds %s %d", m.Name, time.Duration(m.DownAfter)/time.Millisecond),
+			fmt.Sprintf("sentinel failover-timeout %s %d", m.Name, time.Duration(m.FailoverTimeout)/time.Millisecond),
+			fmt.Sprintf("sentinel parallel-syncs %s %d", m.Name, m.ParallelSyncs),
+		)
+		if m.AuthPass != "" {
+			directives = append(directives, fmt.Sprintf("sentinel auth-pass %s %s", m.Name, m.AuthPass))
+		}
+	}
+	return directives
+}
+
+//writes sentinel.conf in basePath, redis-server rewrites it with the state of the monitored masters
+func writeSentinelConf(basePath string, conf *instanceConfig) error {
+	content := "# sentinel configuration generated by sc-redis\n" + strings.Join(conf.redisDirectives(), "\n") + "\n"
+	return ioutil.WriteFile(path.Join(basePath, "sentinel.conf"), []byte(content), 0644)
+}
+
+func sentinelAction(c *cli.Context) {
+	s, err := newSentinelConfig(c)
+	if err != nil {
+		logFatal(err)
+	}
+	run(c, s)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+var (
+	//first bytes of a RDB file, followed by its 4 digits version
+	rdbMagic = []byte("REDIS")
+
+	//CRC64 used by redis (Jones polynomial, reflected) for the checksum ending RDB files since version 5
+	rdbCRCTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+)
+
+//time to wait for the BGSAVE of a snapshot or a backup to complete
+const defaultBGSaveTimeout = 5 * time.Minute
+
+func snapshotAction(c *cli.Context) {
+	ref, output := c.Args().First(), c.String("output")
+	if ref == "" || output == "" {
+		log.Fatal("usage: sc-redis snapshot <uid|ip|ip:port> -o file.rdb")
+	}
+	reg, err := newRegistry(c.GlobalString("state-dir"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ri, err := findInstance(reg, c.GlobalString("working_dir"), ref)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := snapshotInstance(ri, output, c.Duration("timeout")); err != nil {
+		log.Fatal(err)
+	}
+	log.Println(ri.UID, "saved to", output)
+}
+
+//saves the dataset of the instance with BGSAVE and copies its RDB file to output
+func snapshotInstance(ri *runningInstance, output string, timeout time.Duration) error {
+	if err := bgsave(ri.instance, timeout); err != nil {
+		return err
+	}
+	if err := copyFile(ri.hostPath(rdbPath(ri.Config)), output); err != nil {
+		return err
+	}
+	return verifyRDB(output)
+}
+
+//triggers a BGSAVE and waits for LASTSAVE to advance
+func bgsave(i *instance, timeout time.Duration) error {
+	client, err := dialInstance(i, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	last, err := lastSave(client)
+	if err != nil {
+		return err
+	}
+	//LASTSAVE has a 1 second resolution, make sure the new save happens after the last one
+	if time.Now().Unix() == last {
+		time.Sleep(time.Unix(last+1, 0).Sub(time.Now()))
+	}
+	if _, err := client.do("BGSAVE"); err != nil && !strings.Contains(err.Error(), "already in progress") {
+		return err
+	}
+
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(200 * time.Millisecond) {
+		current, err := lastSave(client)
+		if err != nil {
+			return err
+		}
+		if current > last {
+			return nil
+		}
+		info, err := client.info("persistence")
+		if err != nil {
+			return err
+		}
+		if info["rdb_bgsave_in_progress"] == "0" && info["rdb_last_bgsave_status"] == "err" {
+			return fmt.Errorf("BGSAVE failed, see redis-server logs")
+		}
+	}
+	return fmt.Errorf("timeout waiting for BGSAVE to complete")
+}
+
+func lastSave(client *redisClient) (int64, error) {
+	reply, err := client.do("LASTSAVE")
+	if err != nil {
+		return 0, err
+	}
+	last, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected LASTSAVE reply %v", reply)
+	}
+	return last, nil
+}
+
+//copies the RDB file to dst (the host path of the redis RDB file) before redis-server starts
+func restoreRDB(rdb, dst string) error {
+	if err := verifyRDB(rdb); err != nil {
+		return err
+	}
+	return copyFile(rdb, dst)
+}
+
+//checks the RDB magic and version, and the CRC64 checksum ending the file since RDB version 5
+//(a zero checksum means redis was configured with rdbchecksum no). The file is streamed through the checksum
+func verifyRDB(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	header := make([]byte, len(rdbMagic)+4)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:len(rdbMagic)], rdbMagic) {
+		return fmt.Errorf("%s is not a RDB file", file)
+	}
+	version, err := strconv.Atoi(string(header[len(rdbMagic):]))
+	if err != nil {
+		return fmt.Errorf("%s is not a RDB file, invalid version", file)
+	}
+	if version < 5 {
+		return nil
+	}
+
+	//the body ends with the EOF opcode (0xff), followed by the checksum
+	size := fi.Size()
+	if size < int64(len(header))+9 {
+		return fmt.Errorf("%s is truncated", file)
+	}
+	var sum redisCRC64
+	sum.Write(header)
+	if _, err := io.CopyN(&sum, r, size-int64(len(header))-9); err != nil {
+		return fmt.Errorf("%s is truncated: %v", file, err)
+	}
+	trailer := make([]byte, 9)
+	if _, err := io.ReadFull(r, trailer); err != nil || trailer[0] != 0xff {
+		return fmt.Errorf("%s is truncated", file)
+	}
+	sum.Write(trailer[:1])
+	var checksum uint64 //little endian
+	for i := len(trailer) - 1; i > 0; i-- {
+		checksum = checksum<<8 | uint64(trailer[i])
+	}
+	if checksum != 0 && uint64(sum) != checksum {
+		return fmt.Errorf("%s is corrupted, checksum %x expected %x", file, uint64(sum), checksum)
+	}
+	return nil
+}
+
+//redisCRC64 is the checksum of RDB files, redis CRC64 has no initial and final inversion unlike hash/crc64
+type redisCRC64 uint64
+
+func (c *redisCRC64) Write(p []byte) (int, error) {
+	*c = redisCRC64(^crc64.Update(^uint64(*c), rdbCRCTable, p))
+	return len(p), nil
+}
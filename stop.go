@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+func stopAction(c *cli.Context) {
+	ref := c.Args().First()
+	if ref == "" {
+		log.Fatal("usage: sc-redis stop <uid|ip|ip:port>")
+	}
+	reg, err := newRegistry(c.GlobalString("state-dir"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ri, err := findInstance(reg, c.GlobalString("working_dir"), ref)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := stopInstance(reg, ri, !c.Bool("nosave"), c.Duration("timeout")); err != nil {
+		log.Fatal(err)
+	}
+	log.Println(ri.UID, "stopped")
+}
+
+//stops redis-server gracefully: SHUTDOWN, then SIGTERM, then SIGKILL, waiting timeout between each step.
+//The container is then destroyed and its rootfs removed
+func stopInstance(reg *registry, ri *runningInstance, save bool, timeout time.Duration) error {
+	mode := "NOSAVE"
+	if save {
+		mode = "SAVE"
+	}
+	//the host sc-redis process must not restart redis-server
+	if err := reg.requestStop(ri.UID); err != nil {
+		return err
+	}
+	log.Println("shutting down redis-server", ri.UID, mode)
+	if err := shutdownRedis(ri.instance, mode, timeout); err != nil {
+		log.Println("shutdown failed:", err)
+	}
+
+	if !waitForExit(ri.InitPid, timeout) {
+		//the host sc-redis process forwards signals to redis-server with libcontainer.Process.Signal,
+		//signal redis-server directly if it's gone or if it runs the other instances of a topology
+		log.Println("sending SIGTERM")
+		if ri.alive() && ri.Topology == "" {
+			syscall.Kill(ri.Pid, syscall.SIGTERM)
+		} else {
+			syscall.Kill(ri.InitPid, syscall.SIGTERM)
+		}
+		if !waitForExit(ri.InitPid, timeout) {
+			log.Println("sending SIGKILL")
+			syscall.Kill(ri.InitPid, syscall.SIGKILL)
+			waitForExit(ri.InitPid, timeout)
+		}
+	}
+
+	//the host sc-redis process cleans up when redis-server exits, give it a chance to do so
+	waitForCleanup(reg, ri, timeout)
+
+	if _, err := os.Stat(ri.Rootfs); err == nil {
+		if err := ri.container.Destroy(); err != nil {
+			log.Println("destroy failed:", err)
+		}
+		if err := os.RemoveAll(ri.Rootfs); err != nil {
+			return err
+		}
+	}
+	//the host sc-redis process may have removed the rootfs and died before its own cleanup
+	if ri.IP != "" {
+		if err := unpublishPorts(ri.UID); err != nil {
+			log.Println("failed to remove published ports:", err)
+		}
+	}
+	return reg.remove(ri.UID)
+}
+
+func shutdownRedis(i *instance, mode string, timeout time.Duration) error {
+	client, err := dialInstance(i, timeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	//on success, redis closes the connection without replying
+	if _, err := client.do("SHUTDOWN", mode); err != nil && !isConnClosed(err) {
+		return err
+	}
+	return nil
+}
+
+//returns true if the process exited before timeout
+func waitForExit(pid int, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if !processAlive(pid) {
+			return true
+		}
+	}
+	return !processAlive(pid)
+}
+
+//waits for the host sc-redis process to exit or to unregister the instance, returns true if it did before timeout
+func waitForCleanup(reg *registry, ri *runningInstance, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if _, err := reg.load(ri.UID); err != nil || !ri.alive() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+//topology is a manifest of instances launched together by up and torn down by down.
+//The uid of an instance is <topology name>_<instance name>
+type topology struct {
+	Name      string              `json:"name" yaml:"name"` //defaults to the manifest file name
+	Instances []*topologyInstance `json:"instances" yaml:"instances"`
+}
+
+//instance of a topology, its replica_of and sentinel masters may name another instance of the topology
+type topologyInstance struct {
+	Name           string `json:"name" yaml:"name"`
+	instanceConfig `yaml:",inline"`
+
+	uid     string
+	depends []*topologyInstance //instances it replicates or monitors
+}
+
+//instance flags, the instances of a topology are configured by its manifest
+var instanceFlags = []string{"config", "config-file", "name", "ip", "bridge", "subnet", "gateway", "ipv6", "ipv6-subnet",
+	"ipv6-gateway", "replica-of", "master-auth", "memory", "memory-swap", "cpuset", "data-dir", "unix-socket",
+	"unix-socket-owner", "unix-socket-perm"}
+
+//topology names prefix the uid of their instances (<topology>_<instance>), they can't contain _
+var topologyNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]*$`)
+
+//loads a json (.json) or yaml (.yml, .yaml) topology manifest. The instances are validated and sorted
+//in start order, the instances they depend on first
+func loadTopology(file string) (*topology, error) {
+	t := &topology{}
+	if err := unmarshalFile(file, t); err != nil {
+		return nil, fmt.Errorf("invalid topology %s: %v", file, err)
+	}
+	if t.Name == "" {
+		base := filepath.Base(file)
+		t.Name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if !topologyNameRegexp.MatchString(t.Name) {
+		return nil, fmt.Errorf("invalid topology name %q, expecting letters, digits and -", t.Name)
+	}
+	if len(t.Instances) == 0 {
+		return nil, fmt.Errorf("topology %s has no instances", t.Name)
+	}
+
+	byName := map[string]*topologyInstance{}
+	for _, i := range t.Instances {
+		if !nameRegexp.MatchString(i.Name) {
+			return nil, fmt.Errorf("invalid instance name %q, expecting letters, digits, _ and -", i.Name)
+		}
+		if byName[i.Name] != nil {
+			return nil, fmt.Errorf("instance %s defined twice", i.Name)
+		}
+		byName[i.Name] = i
+		i.uid = t.Name + "_" + i.Name
+		if err := validateName(i.uid); err != nil {
+			return nil, err
+		}
+	}
+	for _, i := range t.Instances {
+		//references to other instances are replaced by their uid, resolved once they run
+		refs := []*string{&i.Replication.ReplicaOf}
+		if i.Sentinel != nil {
+			for _, m := range i.Sentinel.Masters {
+				refs = append(refs, &m.Master)
+			}
+		}
+		for _, ref := range refs {
+			if dep := byName[*ref]; dep != nil {
+				*ref = dep.uid
+				i.depends = append(i.depends, dep)
+			}
+		}
+		i.resolvePaths(filepath.Dir(file))
+		if err := i.validate(); err != nil {
+			return nil, fmt.Errorf("instance %s: %v", i.Name, err)
+		}
+	}
+	return t, t.sort()
+}
+
+//sorts the instances in start order, failing on dependency cycles
+func (t *topology) sort() error {
+	var sorted []*topologyInstance
+	visiting, visited := map[*topologyInstance]bool{}, map[*topologyInstance]bool{}
+	var visit func(i *topologyInstance) error
+	visit = func(i *topologyInstance) error {
+		if visited[i] {
+			return nil
+		}
+		if visiting[i] {
+			return fmt.Errorf("instance %s depends on itself through replica_of or sentinel masters", i.Name)
+		}
+		visiting[i] = true
+		for _, dep := range i.depends {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		visited[i] = true
+		sorted = append(sorted, i)
+		return nil
+	}
+	for _, i := range t.Instances {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	t.Instances = sorted
+	return nil
+}
+
+//checks the global flags apply to every instance of the topology, before any of them is started
+func (t *topology) checkFlags(c *cli.Context) error {
+	for _, flag := range instanceFlags {
+		if c.GlobalString(flag) != "" {
+			return fmt.Errorf("--%s is not available with up, set it in the topology instances", flag)
+		}
+	}
+	if len(c.GlobalStringSlice("publish")) > 0 {
+		return fmt.Errorf("--publish is not available with up, set it in the topology instances")
+	}
+	if c.GlobalInt("cpu-shares") != 0 {
+		return fmt.Errorf("--cpu-shares is not available with up, set it in the topology instances")
+	}
+	for _, flag := range []string{"restore", "metrics-addr", "ready-file"} {
+		if c.GlobalString(flag) != "" {
+			return fmt.Errorf("--%s is not available with up", flag)
+		}
+	}
+	if c.GlobalDuration("backup-interval") > 0 {
+		for _, i := range t.Instances {
+			if i.Sentinel != nil {
+				return fmt.Errorf("--backup-interval is not available with up, sentinel instance %s has no dataset to back up", i.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *topology) uids() []string {
+	var uids []string
+	for _, i := range t.Instances {
+		uids = append(uids, i.uid)
+	}
+	return uids
+}
+
+//runs the instances of the topology in the host sc-redis process, one after the other in start order.
+//If an instance fails to start, the started ones are stopped. Returns the highest exit status of the instances
+func (t *topology) up(c *cli.Context) (int, error) {
+	log.SetPrefix("[host] ")
+	reg, err := newRegistry(c.GlobalString("state-dir"))
+	if err != nil {
+		return 1, err
+	}
+	out, err := newLogOutput(c)
+	if err != nil {
+		return 1, err
+	}
+	hostLogger = out.logger(t.Name, false)
+	log.SetOutput(hostLogger.hostWriter())
+
+	for _, i := range t.Instances {
+		if err := checkNameAvailable(reg, c.GlobalString("working_dir"), i.uid); err != nil {
+			return 1, err
+		}
+	}
+
+	log.Println("starting topology", t.Name)
+	var (
+		wg     sync.WaitGroup
+		failed bool
+	)
+	exits := make([]int, len(t.Instances))
+	abort := make(chan struct{})
+	for n, i := range t.Instances {
+		wg.Add(1)
+		exited := make(chan struct{})
+		go func(n int, i *topologyInstance) {
+			defer wg.Done()
+			defer close(exited)
+			l := out.logger(i.uid, true)
+			exit, err := runInstance(c, reg, i.uid, t.Name, &i.instanceConfig, abort, l)
+			if err != nil {
+				l.Println(err)
+				exit = 1
+			}
+			exits[n] = exit
+		}(n, i)
+
+		//the next instances may replicate or monitor this one
+		if !waitForRegistration(reg, i.uid, exited) {
+			//no half started topology: the instances already started are stopped
+			log.Println("instance", i.uid, "failed to start, stopping the topology")
+			close(abort)
+			failed = true
+			break
+		}
+	}
+	wg.Wait()
+
+	exit := 0
+	if failed {
+		exit = 1
+	}
+	for _, e := range exits {
+		if e > exit {
+			exit = e
+		}
+	}
+	return exit, nil
+}
+
+//waits for the instance to be registered with its redis-server process, returns false if it exited before
+func waitForRegistration(reg *registry, uid string, exited <-chan struct{}) bool {
+	for i := 0; i < 300; i++ {
+		if reg.started(uid) {
+			return true
+		}
+		select {
+		case <-exited:
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return false
+}
+
+func upAction(c *cli.Context) {
+	file := c.String("file")
+	if file == "" {
+		log.Fatal("usage: sc-redis up -f <topology.yml>")
+	}
+	t, err := loadTopology(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := t.checkFlags(c); err != nil {
+		log.Fatal(err)
+	}
+	var exit int
+	if c.GlobalBool("detach") && os.Getenv(uidEnv) == "" {
+		//instance names can't contain '.', the topology log can't be the log of a detached instance
+		exit, err = detach(c, t.Name+".topology", t.uids())
+	} else {
+		exit, err = t.up(c)
+	}
+	if err != nil {
+		logFatal(err)
+	}
+	os.Exit(exit)
+}
+
+//stops the instances of the topology in reverse start order
+func downAction(c *cli.Context) {
+	file := c.String("file")
+	if file == "" {
+		log.Fatal("usage: sc-redis down -f <topology.yml>")
+	}
+	t, err := loadTopology(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reg, err := newRegistry(c.GlobalString("state-dir"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for n := len(t.Instances) - 1; n >= 0; n-- {
+		uid := t.Instances[n].uid
+		ri, err := findInstance(reg, c.GlobalString("working_dir"), uid)
+		if err != nil {
+			log.Println(uid, "is not running")
+			continue
+		}
+		if err := stopInstance(reg, ri, !c.Bool("nosave"), c.Duration("timeout")); err != nil {
+			log.Fatal(err)
+		}
+		log.Println(uid, "stopped")
+	}
+}
@@ -2,42 +2,55 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
-	"strconv"
-	"strings"
+	"path"
+	"syscall"
 
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/libcontainer/netlink"
 )
 
-func setupNetBridge() error {
-	// Enable IPv4 forwarding
-	if err := ioutil.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte{'1', '\n'}, 0644); err != nil {
-		return err
+//creates the bridge of the network if needed and sets its gateway addresses
+func setupNetBridge(n *networkConfig) error {
+	if n.IP != "" {
+		// Enable IPv4 forwarding
+		if err := ioutil.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte{'1', '\n'}, 0644); err != nil {
+			return err
+		}
+	}
+	if n.IPv6 != "" {
+		// Enable IPv6 forwarding
+		if err := ioutil.WriteFile("/proc/sys/net/ipv6/conf/all/forwarding", []byte{'1', '\n'}, 0644); err != nil {
+			return err
+		}
 	}
 
-	if err := netlink.CreateBridge(vethBridge, false); err != nil {
+	if err := netlink.CreateBridge(n.Bridge, false); err != nil {
 		// the bridge may already exist, therefore we can ignore an "exists" error
 		if !os.IsExist(err) {
 			return err
 		}
 	}
 
-	iface, err := net.InterfaceByName(vethBridge)
+	iface, err := net.InterfaceByName(n.Bridge)
 	if err != nil {
 		return err
 	}
 
-	ipAddr, ipNet, err := net.ParseCIDR(vethNetwork)
-	if err != nil {
-		return err
+	if n.IP != "" {
+		if err := addBridgeAddr(iface, n.Subnet, n.Gateway); err != nil {
+			return err
+		}
 	}
-
-	if netlink.NetworkLinkAddIp(iface, ipAddr, ipNet); err != nil {
-		return fmt.Errorf("failed to add private network: %s", err)
+	if n.IPv6 != "" {
+		if err := addBridgeAddr(iface, n.IPv6Subnet, n.IPv6Gateway); err != nil {
+			return err
+		}
 	}
 	if err := netlink.NetworkLinkUp(iface); err != nil {
 		return fmt.Errorf("failed to start network bridge: %s", err)
@@ -46,26 +59,228 @@ func setupNetBridge() error {
 	return nil
 }
 
-func validateIPAddr(ip string) error {
-	formatErr := fmt.Errorf("invalid ip address %s. Expecting 172.18.XXX.XXX", ip)
+//adds the gateway address to the bridge, unless an existing bridge already has it
+func addBridgeAddr(iface *net.Interface, subnet, gateway string) error {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return err
+	}
+	gatewayIP := net.ParseIP(gateway)
 
-	comps := strings.Split(ip, ".")
-	if len(comps) != 4 {
-		return formatErr
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return err
 	}
-	if comps[0] != "172" || comps[1] != "18" {
-		return formatErr
+	for _, addr := range addrs {
+		if ip, _, err := net.ParseCIDR(addr.String()); err == nil && ip.Equal(gatewayIP) {
+			return nil
+		}
+	}
+	if err := netlink.NetworkLinkAddIp(iface, gatewayIP, ipNet); err != nil {
+		return fmt.Errorf("failed to add private network %s: %s", subnet, err)
 	}
-	ipID, err := strconv.Atoi(comps[3])
+	return nil
+}
+
+//checks the ip is a host address of the subnet which is not its gateway
+func validateIPAddr(ip, subnet, gateway string) error {
+	_, ipNet, err := net.ParseCIDR(subnet)
 	if err != nil {
 		return err
 	}
-	if ipID < 2 || ipID > 254 {
-		return fmt.Errorf("%s out of ip range (2..254)", comps[3])
+	addr := net.ParseIP(ip)
+	if addr == nil || !ipNet.Contains(addr) {
+		return fmt.Errorf("invalid ip address %s. Expecting an address of %s", ip, subnet)
+	}
+	switch {
+	case addr.Equal(net.ParseIP(gateway)):
+		return fmt.Errorf("%s is the gateway address of %s", ip, subnet)
+	case addr.Equal(ipNet.IP):
+		return fmt.Errorf("%s is the network address of %s", ip, subnet)
+	case addr.To4() != nil && addr.Equal(broadcastAddr(ipNet)):
+		return fmt.Errorf("%s is the broadcast address of %s", ip, subnet)
 	}
 	return nil
 }
 
+//address with the ip and prefix length of the subnet, e.g: 172.18.0.10/16
+func cidrAddr(ip, subnet string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return "", err
+	}
+	ones, _ := ipNet.Mask.Size()
+	return fmt.Sprintf("%s/%d", ip, ones), nil
+}
+
+func broadcastAddr(ipNet *net.IPNet) net.IP {
+	ip := make(net.IP, len(ipNet.IP))
+	for i := range ipNet.IP {
+		ip[i] = ipNet.IP[i] | ^ipNet.Mask[i]
+	}
+	return ip
+}
+
+//lease of a container ip address, it is valid as long as the sc-redis process that took it is running
+type ipLease struct {
+	UID string `json:"uid"`
+	Pid int    `json:"pid"`
+}
+
+//ipam leases container ip addresses from the bridge subnet. Leases are persisted in <state-dir>/ipam/leases.json,
+//protected by a lock file, and keyed by bridge and address (see leaseKey)
+type ipam struct {
+	reg *registry
+}
+
+func newIPAM(reg *registry) *ipam {
+	return &ipam{reg: reg}
+}
+
+//addresses tried by allocate, at most a /16 is walked while the leases are locked
+const maxIPCandidates = 1 << 16
+
+//leases the first free address of the bridge subnet to uid
+func (a *ipam) allocate(uid string, n *networkConfig) (string, error) {
+	var ip string
+	err := a.withLeases(func(leases map[string]*ipLease) error {
+		used := a.usedAddrs(leases)
+		ipNet, err := n.subnet()
+		if err != nil {
+			return err
+		}
+		tried := 0
+		for candidate := nextIP(ipNet.IP); ipNet.Contains(candidate) && tried < maxIPCandidates; candidate = nextIP(candidate) {
+			tried++
+			key := leaseKey(n.Bridge, candidate.String())
+			if _, ok := used[key]; ok || validateIPAddr(candidate.String(), n.Subnet, n.Gateway) != nil {
+				continue
+			}
+			ip = candidate.String()
+			leases[key] = &ipLease{UID: uid, Pid: os.Getpid()}
+			return nil
+		}
+		if tried == maxIPCandidates {
+			return fmt.Errorf("no ip address available in the first %d addresses of %s", maxIPCandidates, n.Subnet)
+		}
+		return fmt.Errorf("no ip address available in %s", n.Subnet)
+	})
+	return ip, err
+}
+
+//leases the given address of bridge to uid, fails if it is used by a running instance
+func (a *ipam) lease(uid, bridge, ip string) error {
+	key := leaseKey(bridge, ip)
+	return a.withLeases(func(leases map[string]*ipLease) error {
+		if owner, ok := a.usedAddrs(leases)[key]; ok {
+			return fmt.Errorf("ip address %s already used by running instance %s", ip, owner)
+		}
+		leases[key] = &ipLease{UID: uid, Pid: os.Getpid()}
+		return nil
+	})
+}
+
+//key of the lease of an address on a bridge: the same address can be used on different bridges and
+//the address is canonicalized (fd00::0005 and fd00::5 are the same)
+func leaseKey(bridge, ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	return bridge + "/" + ip
+}
+
+//releases the addresses leased to uid
+func (a *ipam) release(uid string) error {
+	return a.withLeases(func(leases map[string]*ipLease) error {
+		for ip, l := range leases {
+			if l.UID == uid {
+				delete(leases, ip)
+			}
+		}
+		return nil
+	})
+}
+
+//lease keys of the live leases and of the running instances with the uid using them. Stale leases are removed
+func (a *ipam) usedAddrs(leases map[string]*ipLease) map[string]string {
+	used := map[string]string{}
+	for ip, l := range leases {
+		if processAlive(l.Pid) {
+			used[ip] = l.UID
+		} else {
+			delete(leases, ip)
+		}
+	}
+	if instances, err := a.reg.list(); err == nil {
+		for _, i := range instances {
+			if !i.alive() {
+				continue
+			}
+			bridge := i.Bridge
+			if bridge == "" {
+				bridge = defaultBridge
+			}
+			for _, ip := range []string{i.IP, i.IPv6} {
+				if ip != "" {
+					used[leaseKey(bridge, ip)] = i.UID
+				}
+			}
+		}
+	}
+	return used
+}
+
+//calls fn with the leases while holding the lock, leases are saved if fn succeeds
+func (a *ipam) withLeases(fn func(leases map[string]*ipLease) error) error {
+	//kept apart from the instance files of the registry
+	dir := path.Join(a.reg.dir, "ipam")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(path.Join(dir, "leases.lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	leasesPath := path.Join(dir, "leases.json")
+	leases := map[string]*ipLease{}
+	data, err := ioutil.ReadFile(leasesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &leases); err != nil {
+			return err
+		}
+	}
+
+	if err := fn(leases); err != nil {
+		return err
+	}
+
+	if data, err = json.MarshalIndent(leases, "", "  "); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(leasesPath, data, 0600)
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
 func exportRootfs(basePath string) error {
 	tar, err := Asset("redis_rootfs.tar")
 	if err != nil {
@@ -76,3 +291,27 @@ func exportRootfs(basePath string) error {
 
 	return archive.Untar(buf, basePath, nil)
 }
+
+//copies src to dst, dst is written to a temporary file then renamed so it is never partial
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
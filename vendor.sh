@@ -60,7 +60,9 @@ git_clone github.com/docker/libcontainer b6cf7a6c8520fd21e75f8b3becec6dc355d844b
 git_clone_light github.com/docker/docker v1.4.1
 rm -rf src/github.com/docker/docker/vendor/src/github.com/docker/libcontainer #avoiding double dependency
 
-git_clone_light github.com/codegangsta/cli v1.2.0
+git_clone_light github.com/codegangsta/cli v1.3.0
+
+git_clone_light gopkg.in/yaml.v2 v2
 
 
 echo "don't forget to add vendor folder to your GOPATH (export GOPATH=\$GOPATH:\`pwd\`/vendor)"